@@ -7,6 +7,8 @@ type ApplicationConfig struct {
 	Port                    int    `env:"APPLICATION_PORT" envDefault:"8080"`
 	TZ                      string `env:"APPLICATION_TZ" envDefault:"Asia/Jakarta"`
 	GracefulShutdownTimeout int    `env:"APPLICATION_GRACEFUL_SHUTDOWN_TIMEOUT" envDefault:"10"`
+	// Reflection lets grpcurl and Postman discover the services
+	Reflection bool `env:"APPLICATION_REFLECTION" envDefault:"true"`
 }
 
 func (cfg ApplicationConfig) Address() string {
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ActorColumn        = "actor"
+	ActionColumn       = "action"
+	TargetSerialColumn = "target_serial"
+	IDColumn           = "id"
+
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+type AuditAction string
+
+const (
+	AuditActionMatchMakerCreate  AuditAction = "matchmaker.create"
+	AuditActionMatchMakerImport  AuditAction = "matchmaker.import"
+	AuditActionMatchMakerStart   AuditAction = "matchmaker.start"
+	AuditActionMatchMakerStop    AuditAction = "matchmaker.stop"
+	AuditActionPairCall          AuditAction = "pair.call"
+	AuditActionPeopleRegister    AuditAction = "people.register"
+	AuditActionPeopleUnregister  AuditAction = "people.unregister"
+	AuditActionPeopleRestore     AuditAction = "people.restore"
+	AuditActionPersonErase       AuditAction = "person.erase"
+	AuditActionMatchMakerArchive AuditAction = "matchmaker.archive"
+)
+
+// AuditState is the part of a match maker touched by an action, stored before and after it.
+type AuditState struct {
+	Name   string   `json:"name,omitempty"`
+	Status string   `json:"status,omitempty"`
+	Pair   string   `json:"pair,omitempty"`
+	People []string `json:"people,omitempty"`
+}
+
+type AuditLogEntity struct {
+	ID           uint64
+	Serial       string
+	Actor        string
+	Action       AuditAction
+	TargetSerial string
+	Before       json.RawMessage
+	After        json.RawMessage
+	RequestID    string
+	CreatedAt    time.Time
+}
+
+// NewAuditLogEntity records the action of the actor of the request in the context, a nil state is stored as null.
+func NewAuditLogEntity(ctx context.Context, action AuditAction, targetSerial string, before, after *AuditState) (*AuditLogEntity, error) {
+	beforeData, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterData, err := json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := RequestMetadataFromContext(ctx)
+
+	return &AuditLogEntity{
+		Serial:       GenerateSerial(),
+		Actor:        metadata.Actor,
+		Action:       action,
+		TargetSerial: targetSerial,
+		Before:       beforeData,
+		After:        afterData,
+		RequestID:    metadata.RequestID,
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+type AuditLogEntities []*AuditLogEntity
+
+type AuditLogFilter struct {
+	Actor        string
+	Action       AuditAction
+	TargetSerial string
+	PageSize     int
+	PageToken    string
+}
+
+type AuditLogPage struct {
+	Entries       AuditLogEntities
+	NextPageToken string
+}
+
+type AuditLog struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement"`
+	Serial       string `gorm:"uniqueIndex;size:36"`
+	Actor        string `gorm:"index;size:255"`
+	Action       AuditAction
+	TargetSerial string    `gorm:"index;size:36"`
+	Before       string    `gorm:"type:text"`
+	After        string    `gorm:"type:text"`
+	RequestID    string    `gorm:"size:255"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+func (AuditLog) FromEntity(entity *AuditLogEntity) *AuditLog {
+	if entity == nil {
+		return nil
+	}
+
+	return &AuditLog{
+		Serial:       entity.Serial,
+		Actor:        entity.Actor,
+		Action:       entity.Action,
+		TargetSerial: entity.TargetSerial,
+		Before:       string(entity.Before),
+		After:        string(entity.After),
+		RequestID:    entity.RequestID,
+		CreatedAt:    entity.CreatedAt,
+	}
+}
+
+func (a *AuditLog) ToEntity() *AuditLogEntity {
+	if a == nil {
+		return nil
+	}
+
+	return &AuditLogEntity{
+		ID:           a.ID,
+		Serial:       a.Serial,
+		Actor:        a.Actor,
+		Action:       a.Action,
+		TargetSerial: a.TargetSerial,
+		Before:       []byte(a.Before),
+		After:        []byte(a.After),
+		RequestID:    a.RequestID,
+		CreatedAt:    a.CreatedAt,
+	}
+}
+
+type AuditLogs []*AuditLog
+
+func (a AuditLogs) ToEntities() AuditLogEntities {
+	var entities AuditLogEntities
+	for _, auditLog := range a {
+		if auditLog == nil {
+			continue
+		}
+		entities = append(entities, auditLog.ToEntity())
+	}
+	return entities
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+// AuditRepository is append only, entries are never deleted and only updated to pseudonymise an erased person.
+type AuditRepository interface {
+	CreateAuditLog(ctx context.Context, auditLog *AuditLogEntity) error
+	GetAuditLogs(ctx context.Context, filter AuditLogFilter, beforeID uint64, limit int) (AuditLogEntities, error)
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{
+		db: db,
+	}
+}
+
+// CreateAuditLog appends the entry, inside the transaction of the context when there is one.
+func (r *auditRepository) CreateAuditLog(ctx context.Context, auditLog *AuditLogEntity) error {
+	return transactionOrDB(ctx, r.db).Create(AuditLog{}.FromEntity(auditLog)).Error
+}
+
+// GetAuditLogs returns the newest entries matching the filter, older than beforeID when it isn't zero.
+func (r *auditRepository) GetAuditLogs(ctx context.Context, filter AuditLogFilter, beforeID uint64, limit int) (AuditLogEntities, error) {
+	query := transactionOrDB(ctx, r.db)
+
+	if filter.Actor != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", ActorColumn), filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", ActionColumn), filter.Action)
+	}
+	if filter.TargetSerial != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", TargetSerialColumn), filter.TargetSerial)
+	}
+	if beforeID > 0 {
+		query = query.Where(fmt.Sprintf("%s < ?", IDColumn), beforeID)
+	}
+
+	var auditLogs AuditLogs
+	err := query.Order(fmt.Sprintf("%s DESC", IDColumn)).Limit(limit).Find(&auditLogs).Error
+	if err != nil {
+		return nil, err
+	}
+	return auditLogs.ToEntities(), nil
+}
+
+type auditCall struct {
+	repo AuditRepository
+}
+
+type AuditCall interface {
+	GetAuditLogs(ctx context.Context, filter AuditLogFilter) (*AuditLogPage, error)
+}
+
+func NewAuditCall(auditRepository AuditRepository) AuditCall {
+	return &auditCall{
+		repo: auditRepository,
+	}
+}
+
+// GetAuditLogs returns a page of entries, newest first. The page token of the result is empty on the last page.
+func (ac *auditCall) GetAuditLogs(ctx context.Context, filter AuditLogFilter) (*AuditLogPage, error) {
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultAuditPageSize
+	}
+	if pageSize > maxAuditPageSize {
+		pageSize = maxAuditPageSize
+	}
+
+	beforeID, err := decodeAuditPageToken(filter.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch one more entry to know whether there is a next page
+	entries, err := ac.repo.GetAuditLogs(ctx, filter, beforeID, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AuditLogPage{Entries: entries}
+	if len(entries) > pageSize {
+		page.Entries = entries[:pageSize]
+		page.NextPageToken = encodeAuditPageToken(page.Entries[pageSize-1].ID)
+	}
+
+	return page, nil
+}
+
+func encodeAuditPageToken(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeAuditPageToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid page token")
+	}
+
+	id, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid page token")
+	}
+
+	return id, nil
+}
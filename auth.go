@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// APIKeyActorPrefix is prepended to the name of an API key to make the actor of its requests.
+	APIKeyActorPrefix = "apikey:"
+
+	bearerScheme = "Bearer"
+)
+
+var ErrUnauthenticated = errors.New("a valid API key is required")
+
+type AuthConfig struct {
+	// APIKeys are the name:key pairs of the API clients, the name is recorded as the actor of their changes.
+	// The admin endpoints, such as /audit, /webhooks and /notifications, refuse every request while there are none.
+	APIKeys []string `env:"AUTH_API_KEYS" envSeparator:"," secret:"true"`
+}
+
+func (a AuthConfig) Validate() []error {
+	var errs []error
+
+	names := make(map[string]struct{}, len(a.APIKeys))
+	for i, entry := range a.APIKeys {
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || key == "" {
+			errs = append(errs, fmt.Errorf("AUTH_API_KEYS: entry %d is not name:key", i+1))
+			continue
+		}
+		if _, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("AUTH_API_KEYS: name %s is repeated", name))
+		}
+		names[name] = struct{}{}
+	}
+
+	return errs
+}
+
+type apiKey struct {
+	name string
+	hash [sha256.Size]byte
+}
+
+// Authenticator identifies the callers by the API key sent as a bearer token.
+type Authenticator struct {
+	keys []apiKey
+}
+
+func NewAuthenticator(cfg AuthConfig) *Authenticator {
+	keys := make([]apiKey, 0, len(cfg.APIKeys))
+	for _, entry := range cfg.APIKeys {
+		name, key, _ := strings.Cut(entry, ":")
+		keys = append(keys, apiKey{name: name, hash: sha256.Sum256([]byte(key))})
+	}
+
+	return &Authenticator{keys: keys}
+}
+
+// authenticate returns the name of the API key of the request. A request without a bearer token is not an
+// error, it is anonymous; a token matching no key is.
+func (a *Authenticator) authenticate(r *http.Request) (string, bool, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, bearerScheme) {
+		return "", false, nil
+	}
+
+	name, ok := a.lookup(token)
+	if !ok {
+		return "", false, ErrUnauthenticated
+	}
+
+	return name, true, nil
+}
+
+// lookup returns the name of the API key. Every key is compared, through their hashes, so that the time taken
+// tells nothing about them.
+func (a *Authenticator) lookup(key string) (string, bool) {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	name := ""
+	for _, apiKey := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], apiKey.hash[:]) == 1 {
+			name = apiKey.name
+		}
+	}
+	return name, name != ""
+}
+
+// requireAuthentication refuses the requests that were not authenticated by the request metadata middleware
+// or the dashboard.
+func requireAuthentication(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !RequestMetadataFromContext(r.Context()).Authenticated {
+			writeUnauthenticated(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeUnauthenticated(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("%s realm=%q", bearerScheme, "donut"))
+	writeHTTPError(w, http.StatusUnauthorized, ErrUnauthenticated)
+}
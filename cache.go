@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CacheBackendNone   = "none"
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+
+	redisScanCount = 100
+)
+
+type CacheConfig struct {
+	Backend string        `env:"CACHE_BACKEND" envDefault:"memory" enum:"none,memory,redis"`
+	Size    int           `env:"CACHE_SIZE" envDefault:"10000"`
+	TTL     time.Duration `env:"CACHE_TTL" envDefault:"1m"`
+	Prefix  string        `env:"CACHE_PREFIX" envDefault:"donut:cache:"`
+}
+
+// Cache stores encoded values for a limited time, a value may be evicted at any moment.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, keys ...string) error
+	Clear(ctx context.Context) error
+}
+
+// NewCache returns the cache of the configured backend, or nil when caching is disabled.
+// The memory cache is local to the process, several instances of the server need the redis one.
+func NewCache(cfg *Config) Cache {
+	switch cfg.CacheConfig.Backend {
+	case CacheBackendMemory:
+		return NewMemoryCache(cfg.CacheConfig.Size, cfg.CacheConfig.TTL)
+	case CacheBackendRedis:
+		return NewRedisCache(NewRedisClient(cfg.RedisConfig), cfg.CacheConfig.Prefix, cfg.CacheConfig.TTL)
+	default:
+		return nil
+	}
+}
+
+type memoryCache struct {
+	lru *expirable.LRU[string, []byte]
+}
+
+func NewMemoryCache(size int, ttl time.Duration) Cache {
+	return &memoryCache{
+		lru: expirable.NewLRU[string, []byte](size, nil, ttl),
+	}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok := c.lru.Get(key)
+	return value, ok, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte) error {
+	c.lru.Add(key, value)
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.lru.Remove(key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Clear(ctx context.Context) error {
+	c.lru.Purge()
+	return nil
+}
+
+// redisCache shares the cache between the instances of the server, its keys are namespaced with the prefix.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func NewRedisCache(client *redis.Client, prefix string, ttl time.Duration) Cache {
+	return &redisCache{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte) error {
+	return c.client.Set(ctx, c.prefix+key, value, c.ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, c.prefix+key)
+	}
+	return c.client.Del(ctx, prefixed...).Err()
+}
+
+// Clear deletes the keys of the prefix only, the rest of the redis database is left alone.
+func (c *redisCache) Clear(ctx context.Context) error {
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+type afterTransactionKey struct{}
+
+// afterTransactionHooks are run once the outermost transaction of a service call has ended.
+type afterTransactionHooks struct {
+	mu    sync.Mutex
+	hooks []func()
+}
+
+// withAfterTransaction collects the hooks registered with afterTransaction, the returned function runs them.
+// It does nothing for a nested transaction, whose hooks are run by the outermost one.
+func withAfterTransaction(ctx context.Context) (context.Context, func()) {
+	if _, ok := ctx.Value(afterTransactionKey{}).(*afterTransactionHooks); ok {
+		return ctx, func() {}
+	}
+
+	hooks := &afterTransactionHooks{}
+	return context.WithValue(ctx, afterTransactionKey{}, hooks), func() {
+		hooks.mu.Lock()
+		defer hooks.mu.Unlock()
+
+		for _, hook := range hooks.hooks {
+			hook()
+		}
+		hooks.hooks = nil
+	}
+}
+
+// afterTransaction registers the hook to run when the transaction of the context ends, and reports whether it did.
+func afterTransaction(ctx context.Context, hook func()) bool {
+	hooks, ok := ctx.Value(afterTransactionKey{}).(*afterTransactionHooks)
+	if !ok {
+		return false
+	}
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+
+	hooks.hooks = append(hooks.hooks, hook)
+	return true
+}
+
+const (
+	cacheMatchMaker = "matchmaker"
+	cacheUsers      = "users"
+	cachePair       = "pair"
+)
+
+func matchMakerCacheKey(serial string) string {
+	return cacheMatchMaker + ":" + serial
+}
+
+func usersCacheKey(matchMakerSerial string) string {
+	return cacheUsers + ":" + matchMakerSerial
+}
+
+func pairCacheKey(pairSerial string) string {
+	return cachePair + ":" + pairSerial
+}
+
+// cachedDonutRepository caches the match makers and their users read by serial. Every write through it
+// invalidates the keys it touches, once right away and once more when the transaction of the call ends,
+// so that a read racing with the transaction can't keep the previous value. The reads of a transaction
+// skip the cache and the cache is always filled from the primary, never from a lagging replica.
+type cachedDonutRepository struct {
+	DonutRepository
+	cache   Cache
+	metrics *Metrics
+}
+
+// NewCachedDonutRepository wraps the repository with the cache, or returns it unchanged without a cache.
+func NewCachedDonutRepository(repo DonutRepository, cache Cache, metrics *Metrics) DonutRepository {
+	if cache == nil {
+		return repo
+	}
+
+	return &cachedDonutRepository{
+		DonutRepository: repo,
+		cache:           cache,
+		metrics:         metrics,
+	}
+}
+
+// get decodes the cached value of the key into value and reports whether it was there,
+// a broken cache is reported as a miss so that the call falls back to the database.
+func (r *cachedDonutRepository) get(ctx context.Context, name, key string, value interface{}) bool {
+	data, ok, err := r.cache.Get(ctx, key)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("failed to read cache")
+	}
+	if ok && err == nil {
+		err = json.Unmarshal(data, value)
+		if err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("failed to decode cached value")
+		}
+	}
+
+	hit := ok && err == nil
+	r.metrics.ObserveCache(name, hit)
+	return hit
+}
+
+func (r *cachedDonutRepository) set(ctx context.Context, key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = r.cache.Set(ctx, key, data)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("failed to write cache")
+	}
+}
+
+func (r *cachedDonutRepository) invalidate(ctx context.Context, keys ...string) {
+	deleteKeys := func() {
+		if err := r.cache.Delete(context.WithoutCancel(ctx), keys...); err != nil {
+			log.Error().Err(err).Strs("keys", keys).Msg("failed to invalidate cache")
+		}
+	}
+
+	deleteKeys()
+	afterTransaction(ctx, deleteKeys)
+}
+
+// invalidateUsers invalidates the users of every match maker of the given users.
+func (r *cachedDonutRepository) invalidateUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) {
+	matchMakerSerials, _ := groupPeopleByMatchMaker(matchMakerUsers)
+
+	keys := make([]string, 0, len(matchMakerSerials))
+	for _, matchMakerSerial := range matchMakerSerials {
+		keys = append(keys, usersCacheKey(matchMakerSerial))
+	}
+	r.invalidate(ctx, keys...)
+}
+
+func (r *cachedDonutRepository) GetMatchMakerBySerial(ctx context.Context, serial string) (*MatchMakerEntity, error) {
+	if inTransaction(ctx) {
+		return r.DonutRepository.GetMatchMakerBySerial(ctx, serial)
+	}
+
+	key := matchMakerCacheKey(serial)
+
+	var matchMaker MatchMakerEntity
+	if r.get(ctx, cacheMatchMaker, key, &matchMaker) {
+		return &matchMaker, nil
+	}
+
+	found, err := r.DonutRepository.GetMatchMakerBySerial(withoutReplicaReads(ctx), serial)
+	if err != nil {
+		return nil, err
+	}
+
+	r.set(ctx, key, found)
+	return found, nil
+}
+
+func (r *cachedDonutRepository) GetUsersByMatchMakerSerial(ctx context.Context, matchMakerSerial string) (MatchMakerUserEntities, error) {
+	if inTransaction(ctx) {
+		return r.DonutRepository.GetUsersByMatchMakerSerial(ctx, matchMakerSerial)
+	}
+
+	key := usersCacheKey(matchMakerSerial)
+
+	var matchMakerUsers MatchMakerUserEntities
+	if r.get(ctx, cacheUsers, key, &matchMakerUsers) {
+		return matchMakerUsers, nil
+	}
+
+	matchMakerUsers, err := r.DonutRepository.GetUsersByMatchMakerSerial(withoutReplicaReads(ctx), matchMakerSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	r.set(ctx, key, matchMakerUsers)
+	return matchMakerUsers, nil
+}
+
+// GetUsersBySerial looks up the users of a pair. A pair never moves to another match maker, so the cache
+// only keeps the match maker of the pair and picks the pair out of the cached users of that match maker.
+func (r *cachedDonutRepository) GetUsersBySerial(ctx context.Context, serial string) (MatchMakerUserEntities, error) {
+	if inTransaction(ctx) {
+		return r.DonutRepository.GetUsersBySerial(ctx, serial)
+	}
+
+	key := pairCacheKey(serial)
+
+	var matchMakerSerial string
+	if r.get(ctx, cachePair, key, &matchMakerSerial) {
+		matchMakerUsers, err := r.GetUsersByMatchMakerSerial(ctx, matchMakerSerial)
+		if err != nil {
+			return nil, err
+		}
+
+		pair := make(MatchMakerUserEntities, 0)
+		for _, matchMakerUser := range matchMakerUsers {
+			if matchMakerUser != nil && matchMakerUser.Serial == serial {
+				pair = append(pair, matchMakerUser)
+			}
+		}
+		return pair, nil
+	}
+
+	matchMakerUsers, err := r.DonutRepository.GetUsersBySerial(withoutReplicaReads(ctx), serial)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matchMakerUsers) > 0 && matchMakerUsers[0] != nil {
+		r.set(ctx, key, matchMakerUsers[0].MatchMakerSerial)
+	}
+	return matchMakerUsers, nil
+}
+
+func (r *cachedDonutRepository) CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) error {
+	defer r.invalidate(ctx, matchMakerCacheKey(matchMaker.Serial))
+	return r.DonutRepository.CreateMatchMaker(ctx, matchMaker)
+}
+
+func (r *cachedDonutRepository) UpdateMatchMakerStatusBySerial(ctx context.Context, serial string, status MatchMakerStatus) error {
+	defer r.invalidate(ctx, matchMakerCacheKey(serial))
+	return r.DonutRepository.UpdateMatchMakerStatusBySerial(ctx, serial, status)
+}
+
+func (r *cachedDonutRepository) SwapMatchMakerStatusBySerial(ctx context.Context, serial string, from, to MatchMakerStatus) (bool, error) {
+	defer r.invalidate(ctx, matchMakerCacheKey(serial))
+	return r.DonutRepository.SwapMatchMakerStatusBySerial(ctx, serial, from, to)
+}
+
+func (r *cachedDonutRepository) ArchiveMatchMaker(ctx context.Context, serial string) error {
+	defer r.invalidate(ctx, matchMakerCacheKey(serial), usersCacheKey(serial))
+	return r.DonutRepository.ArchiveMatchMaker(ctx, serial)
+}
+
+func (r *cachedDonutRepository) CreateMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.CreateMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+func (r *cachedDonutRepository) UpdateSerialMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.UpdateSerialMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+func (r *cachedDonutRepository) UpdateSerialMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error {
+	defer r.invalidateUsers(ctx, MatchMakerUserEntities{matchMakerUser})
+	return r.DonutRepository.UpdateSerialMatchMakerUser(ctx, matchMakerUser)
+}
+
+func (r *cachedDonutRepository) UpdateStatusMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.UpdateStatusMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+func (r *cachedDonutRepository) UpdateStatusMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error {
+	defer r.invalidateUsers(ctx, MatchMakerUserEntities{matchMakerUser})
+	return r.DonutRepository.UpdateStatusMatchMakerUser(ctx, matchMakerUser)
+}
+
+func (r *cachedDonutRepository) DeleteMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.DeleteMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+func (r *cachedDonutRepository) RestoreMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error) {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.RestoreMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+func (r *cachedDonutRepository) ReregisterMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error) {
+	defer r.invalidateUsers(ctx, matchMakerUsers)
+	return r.DonutRepository.ReregisterMatchMakerUsers(ctx, matchMakerUsers)
+}
+
+// cachedErasureRepository clears the whole cache after an erasure, the person may be cached in any match maker.
+type cachedErasureRepository struct {
+	ErasureRepository
+	cache Cache
+}
+
+func NewCachedErasureRepository(repo ErasureRepository, cache Cache) ErasureRepository {
+	if cache == nil {
+		return repo
+	}
+
+	return &cachedErasureRepository{
+		ErasureRepository: repo,
+		cache:             cache,
+	}
+}
+
+func (r *cachedErasureRepository) PseudonymiseUserReference(ctx context.Context, reference, pseudonym string) (int64, error) {
+	clearCache := func() {
+		if err := r.cache.Clear(context.WithoutCancel(ctx)); err != nil {
+			log.Error().Err(err).Msg("failed to clear cache")
+		}
+	}
+	defer afterTransaction(ctx, clearCache)
+	defer clearCache()
+
+	return r.ErasureRepository.PseudonymiseUserReference(ctx, reference, pseudonym)
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+)
+
+const commandUsage = `usage: donut [-config file] [-set key=value]... [command]
+
+  serve                                        run the server, the default without a command
+  migrate                                      migrate the database schema
+  config print                                 print the effective config with the secrets redacted
+  matchmaker create -name <name> [-description text] [-start RFC3339] [-duration days]
+  matchmaker list [-status pending,running,...]
+  matchmaker start|stop|info <matchmaker serial>
+  people register|unregister <matchmaker serial> <reference>...
+  people list <matchmaker serial>
+  pairs show <matchmaker serial>
+  export [-format csv|json] [-output file] <matchmaker serial>
+  import -name <name> [-description text] [-start RFC3339] [-duration days] <people.csv>
+  erase -api-key <key> <user reference>
+
+The matchmaker, people, pairs and erase commands accept -server <url> to call a running server
+instead of the database, -api-key <key> to authenticate with it, and -json to print JSON
+instead of a table. The erase command requires an API key, which is checked against
+AUTH_API_KEYS without -server.`
+
+// RunCommand runs a one-off subcommand instead of serving.
+func RunCommand(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("command is empty\n%s", commandUsage)
+	}
+
+	ctx = WithRequestMetadata(ctx, RequestMetadata{
+		Actor:     CLIActor,
+		RequestID: GenerateSerial(),
+	})
+
+	switch args[0] {
+	case "migrate":
+		return runMigrateCommand(ctx, cfg)
+	case "config":
+		if len(args) != 2 || args[1] != "print" {
+			return fmt.Errorf("usage: donut config print")
+		}
+		return cfg.Print(os.Stdout)
+	case "matchmaker":
+		return runMatchMakerCommand(ctx, cfg, args[1:])
+	case "people":
+		return runPeopleCommand(ctx, cfg, args[1:])
+	case "pairs":
+		return runPairsCommand(ctx, cfg, args[1:])
+	case "export", "import":
+		donut, err := newLocalDonutCall(cfg)
+		if err != nil {
+			return err
+		}
+
+		if args[0] == "export" {
+			return runExportCommand(ctx, donut, args[1:])
+		}
+		return runImportCommand(ctx, cfg, donut, args[1:])
+	case "erase":
+		return runEraseCommand(ctx, cfg, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Println(commandUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command: %s\n%s", args[0], commandUsage)
+	}
+}
+
+// AdminCall is the part of DonutCall used by the admin commands. It is served by the local DonutCall
+// against the database, or by a running server through its ConnectRPC API and, for the listing and the erasure,
+// its HTTP endpoints.
+type AdminCall interface {
+	CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) (string, error)
+	ListMatchMakers(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error)
+	Start(ctx context.Context, matchMakerSerial string) error
+	Stop(ctx context.Context, matchMakerSerial string) error
+	GetInformation(ctx context.Context, matchMakerSerial string) (*MatchMakerInformation, error)
+
+	GetPeople(ctx context.Context, matchMakerSerial string) (People, error)
+	GetPeoplePair(ctx context.Context, matchMakerSerial string) (MatchMap, error)
+	RegisterPeople(ctx context.Context, people MatchMakerUserEntities) error
+	UnRegisterPeople(ctx context.Context, people MatchMakerUserEntities) error
+
+	ErasePerson(ctx context.Context, reference string) (string, int64, error)
+}
+
+func newLocalDonutCall(cfg *Config) (DonutCall, error) {
+	db, err := NewDatabaseInstance(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the redis cache is shared with the servers, so the command invalidates their entries after its
+	// changes. A memory cache would be the command's own, the servers keep serving their entries until they expire.
+	var cache Cache
+	switch cfg.CacheConfig.Backend {
+	case CacheBackendRedis:
+		cache = NewCache(cfg)
+	case CacheBackendMemory:
+		log.Warn().Dur("ttl", cfg.CacheConfig.TTL).Msg("servers caching in memory keep serving the values changed by this command until the cache TTL, use the redis cache to invalidate them")
+	}
+
+	return NewDonutCall(
+		NewCachedDonutRepository(NewDonutRepository(db), cache, nil),
+		NewOutboxRepository(db),
+		NewAuditRepository(db),
+		NewCachedErasureRepository(NewErasureRepository(db), cache),
+		nil,
+	), nil
+}
+
+type adminOptions struct {
+	server string
+	apiKey string
+	json   bool
+}
+
+func newAdminFlagSet(name string) (*flag.FlagSet, *adminOptions) {
+	options := &adminOptions{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&options.server, "server", os.Getenv("DONUT_SERVER"), "url of a running server, defaults to the database")
+	fs.StringVar(&options.apiKey, "api-key", os.Getenv("DONUT_API_KEY"), "API key sent to the server, defaults to $DONUT_API_KEY")
+	fs.BoolVar(&options.json, "json", false, "print JSON instead of a table")
+	return fs, options
+}
+
+func (o *adminOptions) call(cfg *Config) (AdminCall, error) {
+	if o.server != "" {
+		return NewRemoteAdminCall(o.server, o.apiKey), nil
+	}
+	return newLocalDonutCall(cfg)
+}
+
+// print writes the value as indented JSON, or as the table written by table.
+func (o *adminOptions) print(value interface{}, table func(w io.Writer)) error {
+	if o.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// runMigrateCommand handles `donut migrate`.
+func runMigrateCommand(ctx context.Context, cfg *Config) error {
+	db, err := NewDatabaseInstance(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := Migrate(db); err != nil {
+		return err
+	}
+
+	version, err := CurrentSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("database schema is at version %d\n", version)
+	return nil
+}
+
+type matchMakerOutput struct {
+	Serial      string           `json:"serial"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Status      MatchMakerStatus `json:"status"`
+	StartTime   time.Time        `json:"start_time"`
+	EndTime     time.Time        `json:"end_time"`
+}
+
+func runMatchMakerCommand(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: donut matchmaker create|list|start|stop|info")
+	}
+
+	fs, options := newAdminFlagSet("matchmaker " + args[0])
+
+	switch args[0] {
+	case "create":
+		name := fs.String("name", "", "match maker name")
+		description := fs.String("description", "", "match maker description")
+		start := fs.String("start", "", "match maker start time in RFC 3339, defaults to now")
+		duration := fs.Int("duration", 1, "match maker duration in days")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		matchMaker, err := parseMatchMakerFlags(*name, *description, *start, *duration, cfg.ValidationConfig)
+		if err != nil {
+			return err
+		}
+
+		call, err := options.call(cfg)
+		if err != nil {
+			return err
+		}
+
+		serial, err := call.CreateMatchMaker(ctx, matchMaker)
+		if err != nil {
+			return err
+		}
+
+		return options.print(map[string]string{"serial": serial}, func(w io.Writer) {
+			fmt.Fprintf(w, "created match maker %s\n", serial)
+		})
+	case "list":
+		status := fs.String("status", "", "comma separated statuses to list, defaults to every status")
+
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		var statuses []MatchMakerStatus
+		for _, s := range strings.Split(*status, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				statuses = append(statuses, MatchMakerStatus(s))
+			}
+		}
+
+		call, err := options.call(cfg)
+		if err != nil {
+			return err
+		}
+
+		matchMakers, err := call.ListMatchMakers(ctx, statuses)
+		if err != nil {
+			return err
+		}
+
+		output := make([]matchMakerOutput, 0, len(matchMakers))
+		for _, matchMaker := range matchMakers {
+			output = append(output, matchMakerOutput{
+				Serial:      matchMaker.Serial,
+				Name:        matchMaker.Name,
+				Description: matchMaker.Description,
+				Status:      matchMaker.Status,
+				StartTime:   matchMaker.StartTime,
+				EndTime:     matchMaker.StartTime.Add(matchMaker.Duration),
+			})
+		}
+
+		return options.print(output, func(w io.Writer) {
+			fmt.Fprintln(w, "SERIAL\tNAME\tSTATUS\tSTART\tEND")
+			for _, matchMaker := range output {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					matchMaker.Serial,
+					matchMaker.Name,
+					matchMaker.Status,
+					matchMaker.StartTime.Format(time.RFC3339),
+					matchMaker.EndTime.Format(time.RFC3339),
+				)
+			}
+		})
+	case "start", "stop", "info":
+		serial, err := parseSerialArgument(fs, args)
+		if err != nil {
+			return err
+		}
+
+		call, err := options.call(cfg)
+		if err != nil {
+			return err
+		}
+
+		switch args[0] {
+		case "start":
+			err = call.Start(ctx, serial)
+		case "stop":
+			err = call.Stop(ctx, serial)
+		default:
+			return runMatchMakerInfo(ctx, call, options, serial)
+		}
+		if err != nil {
+			return err
+		}
+
+		return options.print(map[string]string{"serial": serial, "action": args[0]}, func(w io.Writer) {
+			fmt.Fprintf(w, "%s match maker %s\n", map[string]string{"start": "started", "stop": "stopped"}[args[0]], serial)
+		})
+	default:
+		return fmt.Errorf("unknown matchmaker command: %s", args[0])
+	}
+}
+
+func runMatchMakerInfo(ctx context.Context, call AdminCall, options *adminOptions, serial string) error {
+	info, err := call.GetInformation(ctx, serial)
+	if err != nil {
+		return err
+	}
+
+	export := NewMatchMakerExport(info)
+	return options.print(export, func(w io.Writer) {
+		fmt.Fprintf(w, "SERIAL\t%s\n", export.Serial)
+		fmt.Fprintf(w, "NAME\t%s\n", export.Name)
+		fmt.Fprintf(w, "DESCRIPTION\t%s\n", export.Description)
+		fmt.Fprintf(w, "STATUS\t%s\n", export.Status)
+		fmt.Fprintf(w, "START\t%s\n", export.StartTime.Format(time.RFC3339))
+		fmt.Fprintf(w, "END\t%s\n", export.EndTime.Format(time.RFC3339))
+		fmt.Fprintf(w, "PEOPLE\t%d\n", len(export.People))
+		fmt.Fprintf(w, "PAIRS\t%d\n", len(export.Pairs))
+	})
+}
+
+func runPeopleCommand(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: donut people register|unregister|list")
+	}
+
+	fs, options := newAdminFlagSet("people " + args[0])
+
+	switch args[0] {
+	case "register", "unregister":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if fs.NArg() < 2 {
+			return fmt.Errorf("usage: donut people %s <matchmaker serial> <reference>...", args[0])
+		}
+
+		people := make(MatchMakerUserEntities, 0, fs.NArg()-1)
+		for _, reference := range fs.Args()[1:] {
+			entity := &MatchMakerUserEntity{}
+			people = append(people, entity.Build(
+				WithMatchMakerUserEntityMatchMakerSerial(fs.Arg(0)),
+				WithMatchMakerUserEntityUserReference(reference),
+			))
+		}
+
+		call, err := options.call(cfg)
+		if err != nil {
+			return err
+		}
+
+		if args[0] == "register" {
+			err = call.RegisterPeople(ctx, people)
+		} else {
+			err = call.UnRegisterPeople(ctx, people)
+		}
+		if err != nil {
+			return err
+		}
+
+		references := people.ToPeople().ToUserReferences()
+		return options.print(map[string]interface{}{"matchmaker_serial": fs.Arg(0), "action": args[0], "people": references}, func(w io.Writer) {
+			fmt.Fprintf(w, "%sed %d people in match maker %s\n", args[0], len(references), fs.Arg(0))
+		})
+	case "list":
+		serial, err := parseSerialArgument(fs, args)
+		if err != nil {
+			return err
+		}
+
+		call, err := options.call(cfg)
+		if err != nil {
+			return err
+		}
+
+		people, err := call.GetPeople(ctx, serial)
+		if err != nil {
+			return err
+		}
+
+		references := people.ToUserReferences()
+		if references == nil {
+			references = []string{}
+		}
+
+		return options.print(references, func(w io.Writer) {
+			fmt.Fprintln(w, "REFERENCE")
+			for _, reference := range references {
+				fmt.Fprintln(w, reference)
+			}
+		})
+	default:
+		return fmt.Errorf("unknown people command: %s", args[0])
+	}
+}
+
+func runPairsCommand(ctx context.Context, cfg *Config, args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("usage: donut pairs show <matchmaker serial>")
+	}
+
+	fs, options := newAdminFlagSet("pairs show")
+	serial, err := parseSerialArgument(fs, args)
+	if err != nil {
+		return err
+	}
+
+	call, err := options.call(cfg)
+	if err != nil {
+		return err
+	}
+
+	matchMap, err := call.GetPeoplePair(ctx, serial)
+	if err != nil {
+		return err
+	}
+
+	export := NewMatchMakerExport(&MatchMakerInformation{MatchMaker: &MatchMakerEntity{Serial: serial}, Pairs: matchMap})
+	return options.print(export.Pairs, func(w io.Writer) {
+		fmt.Fprintln(w, "PAIR\tPEOPLE")
+		for _, pair := range export.Pairs {
+			fmt.Fprintf(w, "%s\t%s\n", pair.Serial, strings.Join(pair.References, ", "))
+		}
+	})
+}
+
+// parseSerialArgument parses the flags after the subcommand name and returns its single serial argument.
+func parseSerialArgument(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", err
+	}
+
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("usage: donut %s [-server url] [-json] <matchmaker serial>", fs.Name())
+	}
+
+	return fs.Arg(0), nil
+}
+
+// runExportCommand handles `donut export [-format csv|json] [-output file] <matchmaker serial>`.
+func runExportCommand(ctx context.Context, donut DonutCall, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", string(ExportFormatCSV), "export format, csv or json")
+	output := fs.String("output", "", "output file, defaults to stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: donut export [-format csv|json] [-output file] <matchmaker serial>")
+	}
+
+	exportFormat, err := ParseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	info, err := donut.GetInformation(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return WriteMatchMakerExport(w, info, exportFormat)
+}
+
+// runImportCommand handles `donut import -name <name> [-description text] [-start RFC3339] [-duration days] <people.csv>`.
+func runImportCommand(ctx context.Context, cfg *Config, donut DonutCall, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	name := fs.String("name", "", "match maker name")
+	description := fs.String("description", "", "match maker description")
+	start := fs.String("start", "", "match maker start time in RFC 3339, defaults to now")
+	duration := fs.Int("duration", 1, "match maker duration in days")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: donut import -name <name> [-description text] [-start RFC3339] [-duration days] <people.csv>")
+	}
+
+	matchMaker, err := parseMatchMakerFlags(*name, *description, *start, *duration, cfg.ValidationConfig)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	people, err := ReadMatchMakerImport(file)
+	if err != nil {
+		return err
+	}
+
+	if err := validateImportedPeople(matchMaker.Serial, people, cfg.ValidationConfig); err != nil {
+		return err
+	}
+
+	serial, err := donut.ImportMatchMaker(ctx, matchMaker, people)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d people into match maker %s\n", len(people), serial)
+	return nil
+}
+
+// parseMatchMakerFlags converts the flags of the create and import commands to the request of CreateMatchMaker,
+// so the match maker is validated by the same rules, see NewCreateMatchMakerRequest.
+func parseMatchMakerFlags(name, description, start string, days int, cfg ValidationConfig) (*MatchMakerEntity, error) {
+	var startTime *time.Time
+	if start != "" {
+		parsed, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start time: %w", err)
+		}
+		startTime = &parsed
+	}
+
+	if days < math.MinInt32 || days > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid duration: %d days is out of range", days)
+	}
+
+	msg := NewCreateMatchMakerRequest(name, description, startTime, int32(days))
+	if err := ValidateRequest(msg, cfg); err != nil {
+		return nil, err
+	}
+
+	return parseCreateMatchMakerRequest(connect.NewRequest(msg)), nil
+}
+
+// runEraseCommand handles `donut erase <user reference>`. Erasing cannot be undone, so like POST /people/erase
+// it requires an API key: the server checks it given -server, the command checks it against AUTH_API_KEYS
+// otherwise and records its name as the actor.
+func runEraseCommand(ctx context.Context, cfg *Config, args []string) error {
+	fs, options := newAdminFlagSet("erase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: donut erase [-server url] [-api-key key] <user reference>")
+	}
+	if options.apiKey == "" {
+		return fmt.Errorf("erase requires an API key, pass -api-key or set DONUT_API_KEY")
+	}
+
+	if options.server == "" {
+		name, ok := NewAuthenticator(cfg.AuthConfig).lookup(options.apiKey)
+		if !ok {
+			return ErrUnauthenticated
+		}
+
+		metadata := RequestMetadataFromContext(ctx)
+		metadata.Actor = APIKeyActorPrefix + name
+		metadata.Authenticated = true
+		ctx = WithRequestMetadata(ctx, metadata)
+	}
+
+	call, err := options.call(cfg)
+	if err != nil {
+		return err
+	}
+
+	pseudonym, erased, err := call.ErasePerson(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return options.print(erasePersonResponse{Pseudonym: pseudonym, Erased: erased}, func(w io.Writer) {
+		fmt.Fprintf(w, "erased %d match maker users, the reference is now %s\n", erased, pseudonym)
+	})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"buf.build/gen/go/mocha/remcall/connectrpc/go/donut/v1/donutv1connect"
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"connectrpc.com/connect"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// remoteAdminCall serves the admin commands through the ConnectRPC API of a running server, and through its
+// HTTP endpoints the listing of the match makers and the erasure of a person, which have no procedure.
+type remoteAdminCall struct {
+	server      string
+	client      *http.Client
+	matchMakers donutv1connect.MatchMakerServiceClient
+	people      donutv1connect.PeopleServiceClient
+}
+
+func NewRemoteAdminCall(server, apiKey string) AdminCall {
+	client := newConnectHTTPClient(server, apiKey)
+	server = strings.TrimSuffix(server, "/")
+
+	return &remoteAdminCall{
+		server:      server,
+		client:      client,
+		matchMakers: donutv1connect.NewMatchMakerServiceClient(client, server),
+		people:      donutv1connect.NewPeopleServiceClient(client, server),
+	}
+}
+
+// newConnectHTTPClient speaks HTTP/2 to the server, over cleartext for http URLs as the server uses h2c,
+// which the bidirectional streams of the people service require.
+func newConnectHTTPClient(server, apiKey string) *http.Client {
+	transport := &http2.Transport{}
+	if strings.HasPrefix(server, "http://") {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return &http.Client{
+		Transport: &apiKeyTransport{next: transport, apiKey: apiKey},
+	}
+}
+
+// apiKeyTransport authenticates the requests of the admin commands with the API key, the server records
+// its name as the actor. The requests are anonymous without one.
+type apiKeyTransport struct {
+	next   http.RoundTripper
+	apiKey string
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.apiKey == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", bearerScheme+" "+t.apiKey)
+	return t.next.RoundTrip(req)
+}
+
+func (c *remoteAdminCall) CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) (string, error) {
+	resp, err := c.matchMakers.CreateMatchMaker(ctx, connect.NewRequest(&donutv1.CreateMatchMakerRequest{
+		MatchMaker: &donutv1.MatchMaker{
+			Name:        matchMaker.Name,
+			Description: matchMaker.Description,
+			StartTime:   timestamppb.New(matchMaker.StartTime),
+			Duration:    int32(matchMaker.Duration),
+		},
+	}))
+	if err != nil {
+		return "", err
+	}
+	return resp.Msg.GetSerial(), nil
+}
+
+// ListMatchMakers calls GET /v1/matchmakers.
+func (c *remoteAdminCall) ListMatchMakers(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error) {
+	endpoint := c.server + RESTPrefix + "matchmakers"
+	if len(statuses) > 0 {
+		values := make([]string, 0, len(statuses))
+		for _, status := range statuses {
+			values = append(values, string(status))
+		}
+		endpoint += "?" + url.Values{"status": {strings.Join(values, ",")}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeErrorResponse(resp)
+	}
+
+	var body []restMatchMakerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode match makers: %w", err)
+	}
+
+	matchMakers := make(MatchMakerEntities, 0, len(body))
+	for _, matchMaker := range body {
+		matchMakers = append(matchMakers, &MatchMakerEntity{
+			Serial:      matchMaker.Serial,
+			Name:        matchMaker.Name,
+			Description: matchMaker.Description,
+			Status:      matchMaker.Status,
+			StartTime:   matchMaker.StartTime,
+			Duration:    time.Duration(matchMaker.DurationDays) * Day,
+		})
+	}
+	return matchMakers, nil
+}
+
+// ErasePerson calls POST /people/erase, which has no procedure either.
+func (c *remoteAdminCall) ErasePerson(ctx context.Context, reference string) (string, int64, error) {
+	payload, err := json.Marshal(erasePersonRequest{Reference: reference})
+	if err != nil {
+		return "", 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.server+"/people/erase", bytes.NewReader(payload))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, decodeErrorResponse(resp)
+	}
+
+	var body erasePersonResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("failed to decode erasure: %w", err)
+	}
+	return body.Pseudonym, body.Erased, nil
+}
+
+// decodeErrorResponse returns the error response of the server as a connect error of the code of its status,
+// like the errors of the calls through ConnectRPC.
+func decodeErrorResponse(resp *http.Response) error {
+	var body restErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		body.Error = resp.Status
+	}
+	return connect.NewError(ConnectCode(resp.StatusCode), errors.New(body.Error))
+}
+
+func (c *remoteAdminCall) Start(ctx context.Context, matchMakerSerial string) error {
+	_, err := c.matchMakers.StartMatchMaker(ctx, connect.NewRequest(&donutv1.StartMatchMakerRequest{
+		Serial: matchMakerSerial,
+	}))
+	return err
+}
+
+func (c *remoteAdminCall) Stop(ctx context.Context, matchMakerSerial string) error {
+	_, err := c.matchMakers.StopMatchMaker(ctx, connect.NewRequest(&donutv1.StopMatchMakerRequest{
+		Serial: matchMakerSerial,
+	}))
+	return err
+}
+
+// GetInformation combines the match maker, its people and its pairs, the API doesn't expose the statuses.
+func (c *remoteAdminCall) GetInformation(ctx context.Context, matchMakerSerial string) (*MatchMakerInformation, error) {
+	resp, err := c.matchMakers.GetMatchMakerInformation(ctx, connect.NewRequest(&donutv1.GetMatchMakerInformationRequest{
+		Serial: matchMakerSerial,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := c.GetPeoplePair(ctx, matchMakerSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := resp.Msg.GetMatchMaker()
+	info := &MatchMakerInformation{
+		MatchMaker: &MatchMakerEntity{
+			Serial:      msg.GetSerial(),
+			Name:        msg.GetName(),
+			Description: msg.GetDescription(),
+			StartTime:   msg.GetStartTime().AsTime(),
+			Duration:    time.Duration(msg.GetDuration()) * Day,
+		},
+		Pairs: pairs,
+	}
+
+	for serial, people := range pairs {
+		for _, person := range people {
+			info.Users = append(info.Users, &MatchMakerUserEntity{
+				MatchMakerSerial: matchMakerSerial,
+				Serial:           serial.String(),
+				UserReference:    person.Name,
+			})
+		}
+	}
+
+	return info, nil
+}
+
+func (c *remoteAdminCall) GetPeople(ctx context.Context, matchMakerSerial string) (People, error) {
+	stream := c.people.GetPeople(ctx)
+
+	err := stream.Send(&donutv1.GetPeopleRequest{MatchmakerSerial: matchMakerSerial})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := stream.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseRequest(); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseResponse(); err != nil {
+		return nil, err
+	}
+
+	return parsePeople(resp.GetPeople()), nil
+}
+
+func (c *remoteAdminCall) GetPeoplePair(ctx context.Context, matchMakerSerial string) (MatchMap, error) {
+	resp, err := c.people.GetPeoplePair(ctx, connect.NewRequest(&donutv1.GetPeoplePairRequest{
+		MatchmakerSerial: matchMakerSerial,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	matchMap := make(MatchMap)
+	for _, pair := range resp.Msg.GetPeoplePairs() {
+		matchMap[MatchMakerUserSerial(pair.GetSerial())] = parsePeople(pair.GetPeople())
+	}
+	return matchMap, nil
+}
+
+func (c *remoteAdminCall) RegisterPeople(ctx context.Context, people MatchMakerUserEntities) error {
+	stream := c.people.RegisterPeople(ctx)
+
+	for _, person := range people {
+		err := stream.Send(&donutv1.RegisterPeopleRequest{
+			MatchmakerSerial: person.MatchMakerSerial,
+			Reference:        person.UserReference,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := stream.Receive(); err != nil {
+			return fmt.Errorf("failed to register %s: %w", person.UserReference, err)
+		}
+	}
+
+	if err := stream.CloseRequest(); err != nil {
+		return err
+	}
+	return stream.CloseResponse()
+}
+
+func (c *remoteAdminCall) UnRegisterPeople(ctx context.Context, people MatchMakerUserEntities) error {
+	stream := c.people.UnRegisterPeople(ctx)
+
+	for _, person := range people {
+		err := stream.Send(&donutv1.UnRegisterPeopleRequest{
+			MatchmakerSerial: person.MatchMakerSerial,
+			Reference:        person.UserReference,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := stream.Receive(); err != nil {
+			return fmt.Errorf("failed to unregister %s: %w", person.UserReference, err)
+		}
+	}
+
+	if err := stream.CloseRequest(); err != nil {
+		return err
+	}
+	return stream.CloseResponse()
+}
+
+func parsePeople(people []*donutv1.Person) People {
+	parsed := make(People, 0, len(people))
+	for _, person := range people {
+		parsed = append(parsed, &Person{Name: person.GetReference()})
+	}
+	return parsed
+}
@@ -1,16 +1,368 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFileEnv names the config file read when none is given with -config.
+	ConfigFileEnv = "DONUT_CONFIG"
+
+	redacted = "[REDACTED]"
 )
 
 type Config struct {
-	ApplicationConfig ApplicationConfig
-	DatabaseConfig    DatabaseConfig
+	ApplicationConfig  ApplicationConfig
+	DatabaseConfig     DatabaseConfig
+	InvitationConfig   InvitationConfig
+	OutboxConfig       OutboxConfig
+	WebhookConfig      WebhookConfig
+	NotificationConfig NotificationConfig
+	RedisConfig        RedisConfig
+	MetricsConfig      MetricsConfig
+	TracingConfig      TracingConfig
+	HealthConfig       HealthConfig
+	IdempotencyConfig  IdempotencyConfig
+	RetentionConfig    RetentionConfig
+	ValidationConfig   ValidationConfig
+	CacheConfig        CacheConfig
+	LimitConfig        LimitConfig
+	CORSConfig         CORSConfig
+	DashboardConfig    DashboardConfig
+	AuthConfig         AuthConfig
 }
 
-func Get() (*Config, error) {
+// Get loads the config in layers, each one overriding the previous: the defaults, the YAML or TOML file,
+// the environment variables and the overrides given on the command line. The file holds one section per
+// prefix of the variables, such as `database: {host: localhost}` for DATABASE_HOST.
+func Get(file string, overrides map[string]string) (*Config, error) {
+	if file == "" {
+		file = os.Getenv(ConfigFileEnv)
+	}
+
+	values := make(map[string]string)
+	if file != "" {
+		fileValues, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileValues {
+			values[name] = value
+		}
+	}
+
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		values[name] = value
+	}
+
+	known := knownConfigNames()
+	for key, value := range overrides {
+		name := ConfigName(key)
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("unknown config override %s", key)
+		}
+		values[name] = value
+	}
+
 	cfg := Config{}
-	err := env.Parse(&cfg)
-	return &cfg, err
+	if err := env.Parse(&cfg, env.Options{Environment: values}); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// ConfigName returns the variable of a key written as in the file, such as database.host for DATABASE_HOST.
+func ConfigName(key string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// configKey is the inverse of ConfigName, it splits the section from the rest of the variable.
+func configKey(name string) (string, string) {
+	section, key, _ := strings.Cut(strings.ToLower(name), "_")
+	return section, key
+}
+
+func readConfigFile(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	document := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", file, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", document, values)
+
+	// Reject the keys that don't configure anything, they are typos more often than not
+	known := knownConfigNames()
+	var errs []error
+	for _, name := range sortedKeys(values) {
+		if _, ok := known[name]; !ok {
+			section, key := configKey(name)
+			errs = append(errs, fmt.Errorf("%s: unknown key %s.%s", file, section, key))
+		}
+	}
+
+	return values, errors.Join(errs...)
+}
+
+// flattenConfig joins the nested keys with underscores, lists are joined with commas as in the variables.
+func flattenConfig(prefix string, value interface{}, values map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			flattenConfig(joinConfigName(prefix, key), nested, values)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[ConfigName(prefix)] = strings.Join(items, ",")
+	case nil:
+		values[ConfigName(prefix)] = ""
+	default:
+		values[ConfigName(prefix)] = fmt.Sprint(v)
+	}
+}
+
+func joinConfigName(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+// configField is a field of the config set by a variable.
+type configField struct {
+	Name  string
+	Field reflect.StructField
+	Value reflect.Value
+}
+
+func configFields(cfg *Config) []configField {
+	var fields []configField
+
+	var walk func(value reflect.Value)
+	walk = func(value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if name := field.Tag.Get("env"); name != "" {
+				fields = append(fields, configField{Name: name, Field: field, Value: value.Field(i)})
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct {
+				walk(value.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+
+	return fields
+}
+
+func knownConfigNames() map[string]struct{} {
+	known := make(map[string]struct{})
+	for _, field := range configFields(&Config{}) {
+		known[field.Name] = struct{}{}
+	}
+	return known
+}
+
+// Validate checks the values accepted by the enum tags and the bounds of the numbers,
+// every invalid value is reported at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	for _, field := range configFields(c) {
+		enum := field.Field.Tag.Get("enum")
+		if enum == "" {
+			continue
+		}
+
+		allowed := strings.Split(enum, ",")
+		values := []string{fmt.Sprint(field.Value.Interface())}
+		if field.Value.Kind() == reflect.Slice {
+			values = field.Value.Interface().([]string)
+		}
+
+		for _, value := range values {
+			if !containsString(allowed, value) {
+				errs = append(errs, fmt.Errorf("%s: %q is not one of %s", field.Name, value, strings.Join(allowed, ", ")))
+			}
+		}
+	}
+
+	if c.ApplicationConfig.Port < 1 || c.ApplicationConfig.Port > 65535 {
+		errs = append(errs, fmt.Errorf("APPLICATION_PORT: %d is not a valid port", c.ApplicationConfig.Port))
+	}
+	if c.TracingConfig.SampleRatio < 0 || c.TracingConfig.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO: %v is not between 0 and 1", c.TracingConfig.SampleRatio))
+	}
+	if c.OutboxConfig.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("OUTBOX_BATCH_SIZE: %d is not positive", c.OutboxConfig.BatchSize))
+	}
+	if c.OutboxConfig.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("OUTBOX_MAX_ATTEMPTS: %d is not positive", c.OutboxConfig.MaxAttempts))
+	}
+	errs = append(errs, c.DatabaseConfig.Validate()...)
+	errs = append(errs, c.AuthConfig.Validate()...)
+	if c.WebhookConfig.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS: %d is not positive", c.WebhookConfig.MaxAttempts))
+	}
+	if c.WebhookConfig.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_BATCH_SIZE: %d is not positive", c.WebhookConfig.BatchSize))
+	}
+	if c.WebhookConfig.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_CONCURRENCY: %d is not positive", c.WebhookConfig.Concurrency))
+	}
+	if key := c.WebhookConfig.SecretKey; key != "" && len(key) < minSecretKeyLength {
+		errs = append(errs, fmt.Errorf("WEBHOOK_SECRET_KEY: is shorter than %d characters", minSecretKeyLength))
+	}
+	if c.NotificationConfig.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("NOTIFICATION_MAX_ATTEMPTS: %d is not positive", c.NotificationConfig.MaxAttempts))
+	}
+	if c.CacheConfig.Size < 1 {
+		errs = append(errs, fmt.Errorf("CACHE_SIZE: %d is not positive", c.CacheConfig.Size))
+	}
+	if c.LimitConfig.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("LIMIT_RATE: %v is not positive", c.LimitConfig.Rate))
+	}
+	if c.LimitConfig.Burst < 1 {
+		errs = append(errs, fmt.Errorf("LIMIT_BURST: %d is not positive", c.LimitConfig.Burst))
+	}
+	if c.LimitConfig.MaxRequestBytes < 1 {
+		errs = append(errs, fmt.Errorf("LIMIT_MAX_REQUEST_BYTES: %d is not positive", c.LimitConfig.MaxRequestBytes))
+	}
+	if c.IdempotencyConfig.Lease == 0 {
+		errs = append(errs, fmt.Errorf("IDEMPOTENCY_LEASE: %s is not positive", c.IdempotencyConfig.Lease))
+	}
+	if c.ValidationConfig.MaxDurationDays < 1 {
+		errs = append(errs, fmt.Errorf("VALIDATION_MAX_DURATION_DAYS: %d is not positive", c.ValidationConfig.MaxDurationDays))
+	}
+
+	// A zero duration disables a delay or a limit, such as HEALTH_DRAIN_DELAY, but none can be negative
+	for _, field := range configFields(c) {
+		if field.Value.Type() == reflect.TypeOf(time.Duration(0)) && field.Value.Int() < 0 {
+			errs = append(errs, fmt.Errorf("%s: %s is negative", field.Name, time.Duration(field.Value.Int())))
+		}
+	}
+
+	// The tickers of the background jobs panic on a non positive interval
+	intervals := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"OUTBOX_INTERVAL", c.OutboxConfig.Interval},
+		{"WEBHOOK_INTERVAL", c.WebhookConfig.Interval},
+		{"NOTIFICATION_REMINDER_INTERVAL", c.NotificationConfig.ReminderInterval},
+		{"IDEMPOTENCY_PURGE_INTERVAL", c.IdempotencyConfig.PurgeInterval},
+		{"RETENTION_INTERVAL", c.RetentionConfig.Interval},
+		{"HEALTH_WATCH_INTERVAL", c.HealthConfig.WatchInterval},
+	}
+	for _, interval := range intervals {
+		if interval.interval == 0 {
+			errs = append(errs, fmt.Errorf("%s: %s is not positive", interval.name, interval.interval))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective config in the layout of the YAML file, the fields tagged as secret are redacted.
+func (c *Config) Print(w io.Writer) error {
+	document := make(map[string]map[string]interface{})
+
+	for _, field := range configFields(c) {
+		section, key := configKey(field.Name)
+		if document[section] == nil {
+			document[section] = make(map[string]interface{})
+		}
+
+		var value interface{}
+		switch {
+		case field.Field.Tag.Get("secret") == "true" && !field.Value.IsZero():
+			value = redacted
+		case field.Value.Type() == reflect.TypeOf(time.Duration(0)):
+			value = time.Duration(field.Value.Int()).String()
+		default:
+			value = field.Value.Interface()
+		}
+
+		document[section][key] = value
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigOverrides collects the repeatable -set key=value flags, the keys are written as in the file or as variables.
+type ConfigOverrides map[string]string
+
+func (o ConfigOverrides) String() string {
+	pairs := make([]string, 0, len(o))
+	for _, key := range sortedKeys(o) {
+		pairs = append(pairs, key+"="+o[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (o ConfigOverrides) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value but got %q", value)
+	}
+	o[key] = v
+	return nil
 }
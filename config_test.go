@@ -0,0 +1,246 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// testConfig returns the defaults of the config, whatever the environment of the test.
+func testConfig(t *testing.T) *Config {
+	t.Helper()
+
+	cfg := &Config{}
+	if err := env.Parse(cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// TestGetLayers sets a variable in each layer, every layer overrides the ones before it:
+// the defaults, the file, the environment and the overrides.
+func TestGetLayers(t *testing.T) {
+	files := map[string]string{
+		"donut.yaml": `
+application:
+  port: 9001
+database:
+  host: file-host
+  schema: file-schema
+outbox:
+  sinks: [log, redis]
+`,
+		"donut.toml": `
+[application]
+port = 9001
+
+[database]
+host = "file-host"
+schema = "file-schema"
+
+[outbox]
+sinks = ["log", "redis"]
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("APPLICATION_PORT", "")
+			os.Unsetenv("APPLICATION_PORT")
+			t.Setenv("DATABASE_HOST", "env-host")
+			t.Setenv("DATABASE_SCHEMA", "env-schema")
+			t.Setenv("IDEMPOTENCY_TTL", "")
+			os.Unsetenv("IDEMPOTENCY_TTL")
+
+			cfg, err := Get(writeConfigFile(t, name, content), map[string]string{
+				"database.schema": "flag-schema",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.IdempotencyConfig.TTL != 24*time.Hour {
+				t.Errorf("expected the default IDEMPOTENCY_TTL but got %s", cfg.IdempotencyConfig.TTL)
+			}
+			if cfg.ApplicationConfig.Port != 9001 {
+				t.Errorf("expected the APPLICATION_PORT of the file but got %d", cfg.ApplicationConfig.Port)
+			}
+			if got := strings.Join(cfg.OutboxConfig.Sinks, ","); got != "log,redis" {
+				t.Errorf("expected the OUTBOX_SINKS list of the file but got %s", got)
+			}
+			if cfg.DatabaseConfig.Host != "env-host" {
+				t.Errorf("expected the DATABASE_HOST of the environment but got %s", cfg.DatabaseConfig.Host)
+			}
+			if cfg.DatabaseConfig.Schema != "flag-schema" {
+				t.Errorf("expected the DATABASE_SCHEMA of the override but got %s", cfg.DatabaseConfig.Schema)
+			}
+		})
+	}
+}
+
+func TestGetUnknownKeys(t *testing.T) {
+	file := writeConfigFile(t, "donut.yaml", `
+database:
+  hots: localhost
+applicaton:
+  port: 8080
+`)
+
+	_, err := Get(file, nil)
+	if err == nil {
+		t.Fatal("expected the unknown keys of the file to be rejected")
+	}
+	for _, key := range []string{"database.hots", "applicaton.port"} {
+		if !strings.Contains(err.Error(), "unknown key "+key) {
+			t.Errorf("expected the error to name %s but got %v", key, err)
+		}
+	}
+
+	_, err = Get("", map[string]string{"database.hots": "localhost"})
+	if err == nil || !strings.Contains(err.Error(), "unknown config override database.hots") {
+		t.Errorf("expected the unknown override to be rejected but got %v", err)
+	}
+
+	_, err = Get(writeConfigFile(t, "donut.json", "{}"), nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported config file") {
+		t.Errorf("expected the json file to be rejected but got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		errors []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "zero delay",
+			modify: func(cfg *Config) {
+				cfg.HealthConfig.DrainDelay = 0
+			},
+		},
+		{
+			name: "enum",
+			modify: func(cfg *Config) {
+				cfg.DatabaseConfig.Dialect = "oracle"
+				cfg.OutboxConfig.Sinks = []string{"log", "kafka"}
+			},
+			errors: []string{
+				`DATABASE_DIALECT: "oracle" is not one of mysql, postgres`,
+				`OUTBOX_SINKS: "kafka" is not one of log, webhook, redis, notify`,
+			},
+		},
+		{
+			name: "ranges",
+			modify: func(cfg *Config) {
+				cfg.ApplicationConfig.Port = 70000
+				cfg.TracingConfig.SampleRatio = 1.5
+				cfg.WebhookConfig.BatchSize = 0
+				cfg.ValidationConfig.MaxDurationDays = 0
+			},
+			errors: []string{
+				"APPLICATION_PORT: 70000 is not a valid port",
+				"TRACING_SAMPLE_RATIO: 1.5 is not between 0 and 1",
+				"WEBHOOK_BATCH_SIZE: 0 is not positive",
+				"VALIDATION_MAX_DURATION_DAYS: 0 is not positive",
+			},
+		},
+		{
+			name: "durations",
+			modify: func(cfg *Config) {
+				cfg.OutboxConfig.Backoff = -time.Second
+				cfg.OutboxConfig.Interval = 0
+				cfg.IdempotencyConfig.Lease = 0
+			},
+			errors: []string{
+				"OUTBOX_BACKOFF: -1s is negative",
+				"OUTBOX_INTERVAL: 0s is not positive",
+				"IDEMPOTENCY_LEASE: 0s is not positive",
+			},
+		},
+		{
+			name: "secret key",
+			modify: func(cfg *Config) {
+				cfg.WebhookConfig.SecretKey = "short"
+			},
+			errors: []string{"WEBHOOK_SECRET_KEY: is shorter than"},
+		},
+	}
+
+	for _, test := range tests {
+		cfg := testConfig(t)
+		test.modify(cfg)
+
+		err := cfg.Validate()
+		if len(test.errors) == 0 && err != nil {
+			t.Errorf("%s: expected a valid config but got %v", test.name, err)
+		}
+		for _, expected := range test.errors {
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expected the error %q but got %v", test.name, expected, err)
+			}
+		}
+	}
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AuthConfig.APIKeys = []string{"ops:api-key-value"}
+	cfg.DatabaseConfig.Password = "database-password-value"
+	cfg.DatabaseConfig.Host = "db.example.com"
+
+	var builder strings.Builder
+	if err := cfg.Print(&builder); err != nil {
+		t.Fatal(err)
+	}
+	output := builder.String()
+
+	for _, secret := range []string{"api-key-value", "database-password-value"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %s to be redacted from\n%s", secret, output)
+		}
+	}
+
+	var document map[string]map[string]interface{}
+	if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		section  string
+		key      string
+		expected interface{}
+	}{
+		{"auth", "api_keys", redacted},
+		{"database", "password", redacted},
+		{"database", "host", "db.example.com"},
+		// An empty secret is printed as is, it tells that it is not set
+		{"dashboard", "password", ""},
+		{"idempotency", "ttl", "24h0m0s"},
+	}
+
+	for _, test := range tests {
+		if value := document[test.section][test.key]; value != test.expected {
+			t.Errorf("%s.%s: expected %v but got %v", test.section, test.key, test.expected, value)
+		}
+	}
+}
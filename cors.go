@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/cors"
+)
+
+type CORSConfig struct {
+	// AllowedOrigins are the origins of the browser clients, such as https://admin.example.com or *,
+	// CORS is disabled without any
+	AllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS" envSeparator:","`
+	AllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS" envDefault:"false"`
+	MaxAge           time.Duration `env:"CORS_MAX_AGE" envDefault:"2h"`
+}
+
+// corsAllowedHeaders are the request headers of the Connect, gRPC-Web and REST clients.
+var corsAllowedHeaders = []string{
+	"Accept-Encoding",
+	"Authorization",
+	"Content-Encoding",
+	"Content-Type",
+	"Connect-Accept-Encoding",
+	"Connect-Content-Encoding",
+	"Connect-Protocol-Version",
+	"Connect-Timeout-Ms",
+	"Grpc-Accept-Encoding",
+	"Grpc-Timeout",
+	"X-Grpc-Web",
+	"X-User-Agent",
+	RequestIDHeader,
+	IdempotencyKeyHeader,
+}
+
+// corsExposedHeaders are the response headers the browser clients need to read, the status and details
+// of the gRPC-Web errors among them.
+var corsExposedHeaders = []string{
+	"Content-Encoding",
+	"Connect-Content-Encoding",
+	"Grpc-Status",
+	"Grpc-Message",
+	"Grpc-Status-Details-Bin",
+	RequestIDHeader,
+	IdempotencyReplayedHeader,
+}
+
+// NewCORSMiddleware answers the preflight requests of the configured origins and adds the CORS headers
+// to their requests, so that Connect-Web clients can call the server from a browser.
+func NewCORSMiddleware(cfg CORSConfig, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+
+	return cors.New(cors.Options{
+		AllowedOrigins: cfg.AllowedOrigins,
+		AllowedMethods: []string{
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodDelete,
+		},
+		AllowedHeaders:   corsAllowedHeaders,
+		ExposedHeaders:   corsExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	}).Handler(next)
+}
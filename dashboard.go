@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// DashboardPath is where the dashboard is served on the mux.
+const DashboardPath = "/dashboard/"
+
+//go:embed dashboard/*.html
+var dashboardFiles embed.FS
+
+type DashboardConfig struct {
+	// The dashboard is disabled until a password is set
+	Username string `env:"DASHBOARD_USERNAME" envDefault:"admin"`
+	Password string `env:"DASHBOARD_PASSWORD" secret:"true"`
+}
+
+type dashboardPage struct {
+	Title   string
+	Path    string
+	User    string
+	Message string
+	Error   string
+	Data    interface{}
+}
+
+type dashboardStatusCount struct {
+	Status string
+	Count  int
+}
+
+type dashboardIndex struct {
+	MatchMakers MatchMakerEntities
+	Statuses    []dashboardStatusCount
+}
+
+type dashboardMatchMaker struct {
+	MatchMaker *MatchMakerEntity
+	People     []MatchMakerUserExport
+	Pairs      []PairExport
+	Statuses   []dashboardStatusCount
+}
+
+// Dashboard serves the web pages of the organizers, behind basic authentication. The actions are made
+// through DonutCall with the dashboard user as the actor, so they are audited like the RPCs.
+type Dashboard struct {
+	svc       DonutCall
+	cfg       *Config
+	templates map[string]*template.Template
+}
+
+// NewDashboard returns the dashboard, or nil when it is disabled.
+func NewDashboard(svc DonutCall, cfg *Config) (*Dashboard, error) {
+	if cfg.DashboardConfig.Password == "" {
+		return nil, nil
+	}
+
+	// The duration of a stored match maker is its real length, the pages show it in days as it is entered
+	funcs := template.FuncMap{
+		"days": func(duration time.Duration) int64 {
+			return int64(duration / Day)
+		},
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, name := range []string{"index", "matchmaker", "error"} {
+		t, err := template.New("layout.html").Funcs(funcs).ParseFS(dashboardFiles, "dashboard/layout.html", fmt.Sprintf("dashboard/%s.html", name))
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = t
+	}
+
+	return &Dashboard{
+		svc:       svc,
+		cfg:       cfg,
+		templates: templates,
+	}, nil
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, ok := d.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="donut", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost && !sameOrigin(r) {
+		http.Error(w, "cross origin request", http.StatusForbidden)
+		return
+	}
+
+	// Record the dashboard user as the actor of the changes
+	metadata := RequestMetadataFromContext(r.Context())
+	metadata.Actor = "dashboard:" + username
+	metadata.Authenticated = true
+	r = r.WithContext(WithRequestMetadata(r.Context(), metadata))
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), strings.TrimSuffix(DashboardPath, "/"))
+	if path == "/" && r.Method == http.MethodGet {
+		d.index(w, r, username)
+		return
+	}
+
+	routes := []struct {
+		method  string
+		pattern string
+		handle  func(w http.ResponseWriter, r *http.Request, username, serial string)
+	}{
+		{http.MethodGet, "/matchmakers/{serial}", d.matchMaker},
+		{http.MethodPost, "/matchmakers/{serial}/start", d.start},
+		{http.MethodPost, "/matchmakers/{serial}/stop", d.stop},
+		{http.MethodPost, "/matchmakers/{serial}/people", d.register},
+		{http.MethodPost, "/matchmakers/{serial}/people/unregister", d.unregister},
+	}
+	for _, route := range routes {
+		params, ok := matchRESTPath(route.pattern, path)
+		if ok && route.method == r.Method {
+			route.handle(w, r, username, params["serial"])
+			return
+		}
+	}
+
+	d.renderError(w, username, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+}
+
+// authenticate checks the basic credentials, comparing their hashes so that the time taken tells nothing.
+func (d *Dashboard) authenticate(r *http.Request) (string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	usernameHash := sha256.Sum256([]byte(username))
+	passwordHash := sha256.Sum256([]byte(password))
+	expectedUsernameHash := sha256.Sum256([]byte(d.cfg.DashboardConfig.Username))
+	expectedPasswordHash := sha256.Sum256([]byte(d.cfg.DashboardConfig.Password))
+
+	usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+	passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+	return username, usernameMatch && passwordMatch
+}
+
+// sameOrigin rejects the forms posted from other sites, the browser sends the basic credentials with them too.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host == r.Host
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request, username string) {
+	matchMakers, err := d.svc.ListMatchMakers(r.Context(), nil)
+	if err != nil {
+		d.renderError(w, username, HTTPStatus(err), err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, matchMaker := range matchMakers {
+		counts[string(matchMaker.Status)]++
+	}
+
+	d.render(w, r, "index", username, http.StatusOK, "Match makers", dashboardIndex{
+		MatchMakers: matchMakers,
+		Statuses: statusCounts(counts, []string{
+			string(MatchMakerStatusPending),
+			string(MatchMakerStatusRunning),
+			string(MatchMakerStatusFinished),
+			string(MatchMakerStatusStopped),
+		}),
+	})
+}
+
+func (d *Dashboard) matchMaker(w http.ResponseWriter, r *http.Request, username, serial string) {
+	info, err := d.svc.GetInformation(r.Context(), serial)
+	if err != nil {
+		d.renderError(w, username, HTTPStatus(err), err)
+		return
+	}
+
+	export := NewMatchMakerExport(info)
+	counts := make(map[string]int)
+	for _, person := range export.People {
+		counts[string(person.Status)]++
+	}
+
+	d.render(w, r, "matchmaker", username, http.StatusOK, info.MatchMaker.Name, dashboardMatchMaker{
+		MatchMaker: info.MatchMaker,
+		People:     export.People,
+		Pairs:      export.Pairs,
+		Statuses: statusCounts(counts, []string{
+			string(MatchMakerUserStatusPending),
+			string(MatchMakerUserStatusRunning),
+			string(MatchMakerUserStatusFinished),
+			string(MatchMakerUserStatusStopped),
+		}),
+	})
+}
+
+func (d *Dashboard) start(w http.ResponseWriter, r *http.Request, username, serial string) {
+	d.redirect(w, r, serial, "The round has started.", d.svc.Start(r.Context(), serial))
+}
+
+func (d *Dashboard) stop(w http.ResponseWriter, r *http.Request, username, serial string) {
+	d.redirect(w, r, serial, "The round has stopped.", d.svc.Stop(r.Context(), serial))
+}
+
+func (d *Dashboard) register(w http.ResponseWriter, r *http.Request, username, serial string) {
+	people := make(MatchMakerUserEntities, 0)
+	for _, reference := range strings.Fields(r.PostFormValue("references")) {
+		msg := &donutv1.RegisterPeopleRequest{MatchmakerSerial: serial, Reference: reference}
+		if err := ValidateRequest(msg, d.cfg.ValidationConfig); err != nil {
+			d.redirect(w, r, serial, "", err)
+			return
+		}
+		people = append(people, parseRegisterPeopleRequest(msg)...)
+	}
+	if len(people) == 0 {
+		d.redirect(w, r, serial, "", NewValidationError("references", "is empty"))
+		return
+	}
+
+	d.redirect(w, r, serial, fmt.Sprintf("%d people registered.", len(people)), d.svc.RegisterPeople(r.Context(), people))
+}
+
+func (d *Dashboard) unregister(w http.ResponseWriter, r *http.Request, username, serial string) {
+	msg := &donutv1.UnRegisterPeopleRequest{MatchmakerSerial: serial, Reference: r.PostFormValue("reference")}
+	if err := ValidateRequest(msg, d.cfg.ValidationConfig); err != nil {
+		d.redirect(w, r, serial, "", err)
+		return
+	}
+
+	err := d.svc.UnRegisterPeople(r.Context(), parseUnRegisterPeopleRequest(msg))
+	d.redirect(w, r, serial, fmt.Sprintf("%s unregistered.", msg.GetReference()), err)
+}
+
+// redirect sends the browser back to the match maker page with the outcome of the action,
+// so that reloading the page doesn't repeat it.
+func (d *Dashboard) redirect(w http.ResponseWriter, r *http.Request, serial, message string, err error) {
+	query := url.Values{}
+	if err != nil {
+		query.Set("error", ErrorMessage(err))
+	} else {
+		query.Set("message", message)
+	}
+
+	location := fmt.Sprintf("%smatchmakers/%s?%s", DashboardPath, url.PathEscape(serial), query.Encode())
+	http.Redirect(w, r, location, http.StatusSeeOther)
+}
+
+func (d *Dashboard) render(w http.ResponseWriter, r *http.Request, name, username string, status int, title string, data interface{}) {
+	d.write(w, name, status, dashboardPage{
+		Title:   title,
+		Path:    DashboardPath,
+		User:    username,
+		Message: r.URL.Query().Get("message"),
+		Error:   r.URL.Query().Get("error"),
+		Data:    data,
+	})
+}
+
+func (d *Dashboard) renderError(w http.ResponseWriter, username string, status int, err error) {
+	d.write(w, "error", status, dashboardPage{
+		Title: http.StatusText(status),
+		Path:  DashboardPath,
+		User:  username,
+		Error: ErrorMessage(err),
+	})
+}
+
+// write renders the page before writing anything, so that a template error still gets a proper status.
+func (d *Dashboard) write(w http.ResponseWriter, name string, status int, page dashboardPage) {
+	var buf bytes.Buffer
+	if err := d.templates[name].ExecuteTemplate(&buf, "layout", page); err != nil {
+		log.Error().Err(err).Str("template", name).Msg("failed to render dashboard")
+		http.Error(w, "failed to render the page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+func statusCounts(counts map[string]int, statuses []string) []dashboardStatusCount {
+	result := make([]dashboardStatusCount, 0, len(statuses))
+	for _, status := range statuses {
+		result = append(result, dashboardStatusCount{Status: status, Count: counts[status]})
+	}
+	return result
+}
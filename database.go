@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -16,43 +24,219 @@ const (
 	DialectPostgres DatabaseDialect = "postgres"
 )
 
+const (
+	TLSModeDisable    = "disable"
+	TLSModeRequire    = "require"
+	TLSModeVerifyCA   = "verify-ca"
+	TLSModeVerifyFull = "verify-full"
+
+	mysqlTLSConfigName = "donut"
+)
+
 type DatabaseConfig struct {
 	Host     string `env:"DATABASE_HOST"`
 	Port     int    `env:"DATABASE_PORT" envDefault:"3306"`
 	Username string `env:"DATABASE_USERNAME" envDefault:"root"`
-	Password string `env:"DATABASE_PASSWORD"`
+	Password string `env:"DATABASE_PASSWORD" secret:"true"`
 	Schema   string `env:"DATABASE_SCHEMA"`
 	Debug    bool   `env:"DATABASE_DEBUG" envDefault:"false"`
 	LogLevel string `env:"DATABASE_LOG_LEVEL" envDefault:"info" enum:"silent,error,warn,info"`
-	Dialect  string `env:"DATABASE_DIALECT"`
+	// Dialect defaults to mysql like the port and the username
+	Dialect string `env:"DATABASE_DIALECT" envDefault:"mysql" enum:"mysql,postgres"`
+
+	// DSN replaces the connection settings above when it is set, the pool settings still apply
+	DSN      string `env:"DATABASE_DSN" secret:"true"`
+	TimeZone string `env:"DATABASE_TIMEZONE" envDefault:"UTC"`
+
+	// ReplicaDSNs are the read replicas in the DSN format of the dialect, see NewReplicaInstances
+	ReplicaDSNs []string `env:"DATABASE_REPLICA_DSNS" envSeparator:"," secret:"true"`
+
+	MaxOpenConns    int           `env:"DATABASE_MAX_OPEN_CONNS" envDefault:"20"`
+	MaxIdleConns    int           `env:"DATABASE_MAX_IDLE_CONNS" envDefault:"10"`
+	ConnMaxLifetime time.Duration `env:"DATABASE_CONN_MAX_LIFETIME" envDefault:"30m"`
+	ConnMaxIdleTime time.Duration `env:"DATABASE_CONN_MAX_IDLE_TIME" envDefault:"5m"`
+
+	TLSMode string `env:"DATABASE_TLS_MODE" envDefault:"disable" enum:"disable,require,verify-ca,verify-full"`
+	TLSCA   string `env:"DATABASE_TLS_CA"`
+	TLSCert string `env:"DATABASE_TLS_CERT"`
+	TLSKey  string `env:"DATABASE_TLS_KEY"`
+
+	AutoMigrate bool `env:"DATABASE_AUTO_MIGRATE" envDefault:"true"`
 }
 
 func (d DatabaseConfig) GetDialector() (gorm.Dialector, error) {
+	if d.DSN != "" {
+		return d.GetDialectorByDSN(d.DSN)
+	}
+
+	switch d.Dialect {
+	case string(DialectMySQL):
+		dsn, err := d.mysqlDSN()
+		if err != nil {
+			return nil, err
+		}
+		return mysql.Open(dsn), nil
+	case string(DialectPostgres):
+		return postgres.Open(d.postgresDSN()), nil
+	default:
+		return nil, fmt.Errorf("unsupported database dialect: %s", d.Dialect)
+	}
+}
+
+// GetDialectorByDSN opens the raw DSN with the driver of the dialect.
+func (d DatabaseConfig) GetDialectorByDSN(dsn string) (gorm.Dialector, error) {
 	switch d.Dialect {
 	case string(DialectMySQL):
-		dsn := fmt.Sprintf(
-			"%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=True&loc=Local",
-			d.Username,
-			d.Password,
-			d.Host,
-			d.Port,
-			d.Schema,
-		)
 		return mysql.Open(dsn), nil
 	case string(DialectPostgres):
-		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=Asia/Jakarta",
-			d.Host,
-			d.Username,
-			d.Password,
-			d.Schema,
-			d.Port,
-		)
 		return postgres.Open(dsn), nil
 	default:
 		return nil, fmt.Errorf("unsupported database dialect: %s", d.Dialect)
 	}
 }
 
+// mysqlDSN parses the times in the configured time zone and registers the TLS settings with the driver.
+func (d DatabaseConfig) mysqlDSN() (string, error) {
+	location, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		return "", err
+	}
+
+	cfg := mysqldriver.NewConfig()
+	cfg.User = d.Username
+	cfg.Passwd = d.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	cfg.DBName = d.Schema
+	cfg.ParseTime = true
+	cfg.Loc = location
+	cfg.Params = map[string]string{"charset": "utf8"}
+
+	if d.TLSMode != "" && d.TLSMode != TLSModeDisable {
+		tlsConfig, err := d.TLSConfig()
+		if err != nil {
+			return "", err
+		}
+		if err := mysqldriver.RegisterTLSConfig(mysqlTLSConfigName, tlsConfig); err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = mysqlTLSConfigName
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// postgresDSN passes the TLS mode and files to the driver, which uses the same names for the modes.
+func (d DatabaseConfig) postgresDSN() string {
+	mode := d.TLSMode
+	if mode == "" {
+		mode = TLSModeDisable
+	}
+
+	params := []string{
+		fmt.Sprintf("host=%s", d.Host),
+		fmt.Sprintf("user=%s", d.Username),
+		fmt.Sprintf("password=%s", d.Password),
+		fmt.Sprintf("dbname=%s", d.Schema),
+		fmt.Sprintf("port=%d", d.Port),
+		fmt.Sprintf("sslmode=%s", mode),
+		fmt.Sprintf("TimeZone=%s", d.TimeZone),
+	}
+	if d.TLSCA != "" {
+		params = append(params, fmt.Sprintf("sslrootcert=%s", d.TLSCA))
+	}
+	if d.TLSCert != "" {
+		params = append(params, fmt.Sprintf("sslcert=%s sslkey=%s", d.TLSCert, d.TLSKey))
+	}
+
+	return strings.Join(params, " ")
+}
+
+// TLSConfig returns the TLS settings of the MySQL connection for the mode, require encrypts without checking
+// the server certificate, verify-ca checks it against the CA and verify-full checks the host name as well.
+func (d DatabaseConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: d.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if d.TLSCA != "" {
+		ca, err := os.ReadFile(d.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database CA: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("database CA %s holds no certificate", d.TLSCA)
+		}
+	}
+
+	if d.TLSCert != "" {
+		certificate, err := tls.LoadX509KeyPair(d.TLSCert, d.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load database client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	switch d.TLSMode {
+	case TLSModeRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case TLSModeVerifyCA:
+		// Verify the chain ourselves as the standard verification also checks the host name
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, tlsConfig.RootCAs)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("database server sent no certificate")
+	}
+
+	certificates := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		certificate, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// Validate checks the settings that the enum tags can't, see Config.Validate.
+func (d DatabaseConfig) Validate() []error {
+	var errs []error
+
+	if _, err := time.LoadLocation(d.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("DATABASE_TIMEZONE: %w", err))
+	}
+	if (d.TLSCert == "") != (d.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("DATABASE_TLS_CERT and DATABASE_TLS_KEY must be set together"))
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("DATABASE_MAX_IDLE_CONNS: %d is more than DATABASE_MAX_OPEN_CONNS %d", d.MaxIdleConns, d.MaxOpenConns))
+	}
+
+	return errs
+}
+
 func (d DatabaseConfig) GetLogLevel() logger.LogLevel {
 	switch d.LogLevel {
 	case "error":
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testDatabaseDSNEnv     = "DONUT_TEST_DATABASE_DSN"
+	testDatabaseDialectEnv = "DONUT_TEST_DATABASE_DIALECT"
+)
+
+// openTestDatabase connects to the database of DONUT_TEST_DATABASE_DSN, a postgres one unless
+// DONUT_TEST_DATABASE_DIALECT says otherwise, the tests and benchmarks needing one are skipped without it.
+// The matchmaker tables, managed outside of the service, are created without the unique index on the
+// pair serial, which the people of a pair share.
+func openTestDatabase(tb testing.TB) *gorm.DB {
+	tb.Helper()
+
+	dsn := os.Getenv(testDatabaseDSNEnv)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDatabaseDSNEnv)
+	}
+
+	cfg := DatabaseConfig{Dialect: os.Getenv(testDatabaseDialectEnv)}
+	if cfg.Dialect == "" {
+		cfg.Dialect = string(DialectPostgres)
+	}
+
+	dialector, err := cfg.GetDialectorByDSN(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&MatchMaker{}, &MatchMakerUser{}); err != nil {
+		tb.Fatal(err)
+	}
+	if db.Migrator().HasIndex(&MatchMakerUser{}, "Serial") {
+		if err := db.Migrator().DropIndex(&MatchMakerUser{}, "Serial"); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := Migrate(db); err != nil {
+		tb.Fatal(err)
+	}
+
+	return db
+}
+
+// createTestMatchMaker creates a pending match maker with the given number of pending people.
+func createTestMatchMaker(tb testing.TB, repo DonutRepository, people int) (*MatchMakerEntity, MatchMakerUserEntities) {
+	tb.Helper()
+	ctx := context.Background()
+
+	matchMaker := (&MatchMakerEntity{}).Build(WithMatchMakerEntityDuration(7))
+	if err := repo.CreateMatchMaker(ctx, matchMaker); err != nil {
+		tb.Fatal(err)
+	}
+
+	matchMakerUsers := make(MatchMakerUserEntities, 0, people)
+	for i := 0; i < people; i++ {
+		matchMakerUsers = append(matchMakerUsers, (&MatchMakerUserEntity{}).Build(
+			WithMatchMakerUserEntityMatchMakerSerial(matchMaker.Serial),
+			WithMatchMakerUserEntitySerial(GenerateSerial()),
+			WithMatchMakerUserEntityUserReference(fmt.Sprintf("person-%05d", i)),
+		))
+	}
+
+	// Stay under the bind parameter limit of the drivers
+	for start := 0; start < len(matchMakerUsers); start += 1000 {
+		end := start + 1000
+		if end > len(matchMakerUsers) {
+			end = len(matchMakerUsers)
+		}
+		if err := repo.CreateMatchMakerUsers(ctx, matchMakerUsers[start:end]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return matchMaker, matchMakerUsers
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"time"
 
 	trmgorm "github.com/avito-tech/go-transaction-manager/drivers/gorm/v2"
 	"github.com/avito-tech/go-transaction-manager/trm/v2"
 	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
 	"github.com/avito-tech/go-transaction-manager/trm/v2/settings"
+	"gorm.io/gorm"
 )
 
+// ErrMatchMakerConcurrentUpdate is returned when another call changed the match maker status first.
+var ErrMatchMakerConcurrentUpdate = errors.New("match maker was updated concurrently")
+
 type donutCall struct {
-	repo DonutRepository
+	repo    DonutRepository
+	outbox  OutboxRepository
+	audit   AuditRepository
+	erasure ErasureRepository
+	metrics *Metrics
 }
 
 type DonutCall interface {
@@ -23,32 +33,99 @@ type DonutCall interface {
 	Call(ctx context.Context, matchMakerSerial string, people People) error
 
 	CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) (string, error)
+	ImportMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity, people MatchMakerUserEntities) (string, error)
 
 	GetInformation(ctx context.Context, matchMakerSerial string) (*MatchMakerInformation, error)
+	ListMatchMakers(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error)
 
 	GetPeople(ctx context.Context, matchMakerSerial string) (People, error)
 	GetFinishedPeople(ctx context.Context, matchMakerSerial string) (People, error)
 	GetPendingPeople(ctx context.Context, matchMakerSerial string) (People, error)
 	GetPeoplePair(ctx context.Context, matchMakerSerial string) (MatchMap, error)
+	GetPairInformation(ctx context.Context, pairSerial string) (*MatchMakerInformation, error)
 
 	RegisterPeople(ctx context.Context, people MatchMakerUserEntities) error
 	UnRegisterPeople(ctx context.Context, people MatchMakerUserEntities) error
+	RestorePeople(ctx context.Context, people MatchMakerUserEntities) (int64, error)
+	PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	ErasePerson(ctx context.Context, reference string) (string, int64, error)
+	ArchiveMatchMakers(ctx context.Context, finishedBefore time.Time) (int64, error)
 }
 
-func NewDonutCall(donutRepository DonutRepository) DonutCall {
+func NewDonutCall(donutRepository DonutRepository, outboxRepository OutboxRepository, auditRepository AuditRepository, erasureRepository ErasureRepository, metrics *Metrics) DonutCall {
 	return &donutCall{
-		repo: donutRepository,
+		repo:    donutRepository,
+		outbox:  outboxRepository,
+		audit:   auditRepository,
+		erasure: erasureRepository,
+		metrics: metrics,
 	}
 }
 
-func (dc *donutCall) Call(ctx context.Context, matchMakerSerial string, people People) error {
+// transaction runs fn inside a database transaction, the repository picks it up from the context.
+func (dc *donutCall) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	trManagerSettingOptions, err := settings.New(settings.WithPropagation(trm.PropagationRequired))
+	if err != nil {
+		return err
+	}
+
+	trManagerSetting, err := trmgorm.NewSettings(trManagerSettingOptions)
+	if err != nil {
+		return err
+	}
+
+	trManager, err := manager.New(trmgorm.NewDefaultFactory(dc.repo.Database()), manager.WithSettings(trManagerSetting))
+	if err != nil {
+		return err
+	}
+
+	// Invalidate the cached values written by the transaction again once it has ended, see cachedDonutRepository
+	ctx, runAfterTransaction := withAfterTransaction(ctx)
+	defer runAfterTransaction()
+
+	return trManager.Do(ctx, fn)
+}
+
+// publish appends the event to the outbox, it has to be called inside the transaction
+// of the state change so the event is stored if and only if the change is committed.
+func (dc *donutCall) publish(ctx context.Context, eventType EventType, payload EventPayload) error {
+	event, err := NewEventEntity(eventType, payload)
+	if err != nil {
+		return err
+	}
+
+	return dc.outbox.CreateEvent(ctx, event)
+}
+
+// record appends the action of the request actor to the audit log, like publish it has to be called
+// inside the transaction of the state change.
+func (dc *donutCall) record(ctx context.Context, action AuditAction, targetSerial string, before, after *AuditState) error {
+	auditLog, err := NewAuditLogEntity(ctx, action, targetSerial, before, after)
+	if err != nil {
+		return err
+	}
+
+	return dc.audit.CreateAuditLog(ctx, auditLog)
+}
+
+// getMatchMaker returns the match maker, or a not found error when there is no match maker with the serial.
+func (dc *donutCall) getMatchMaker(ctx context.Context, matchMakerSerial string) (*MatchMakerEntity, error) {
 	matchMaker, err := dc.repo.GetMatchMakerBySerial(ctx, matchMakerSerial)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, NewNotFoundError("match maker", matchMakerSerial)
+	}
+	return matchMaker, err
+}
+
+func (dc *donutCall) Call(ctx context.Context, matchMakerSerial string, people People) error {
+	matchMaker, err := dc.getMatchMaker(ctx, matchMakerSerial)
 	if err != nil {
 		return err
 	}
 
 	if matchMaker.Status != MatchMakerStatusRunning {
-		return fmt.Errorf("match maker is not running")
+		return NewInvalidTransitionError(matchMakerSerial, matchMaker.Status, "call a pair of")
 	}
 
 	users, err := dc.repo.GetUsersByMatchMakerSerialAndUserReferences(ctx, matchMakerSerial, people.ToUserReferences())
@@ -58,8 +135,8 @@ func (dc *donutCall) Call(ctx context.Context, matchMakerSerial string, people P
 
 	matchMap := users.ToMatchMap()
 
-	if len(matchMap) > 1 {
-		return fmt.Errorf("expected one pair but found %d", len(matchMap))
+	if len(matchMap) != 1 {
+		return NewIncompletePairError("", fmt.Sprintf("expected one pair but found %d", len(matchMap)))
 	}
 
 	matchMakerUserSerial, _ := matchMap.First()
@@ -69,7 +146,7 @@ func (dc *donutCall) Call(ctx context.Context, matchMakerSerial string, people P
 	}
 
 	if len(usersRegistered) != len(people) {
-		return fmt.Errorf("pair is lack of people")
+		return NewIncompletePairError(matchMakerUserSerial.String(), "pair is lack of people")
 	}
 
 	matchMakerUsersEntities := make(MatchMakerUserEntities, 0)
@@ -90,28 +167,53 @@ func (dc *donutCall) Call(ctx context.Context, matchMakerSerial string, people P
 		matchMakerUsersEntities = append(matchMakerUsersEntities, matchMakerUser)
 	}
 
-	return dc.repo.UpdateStatusMatchMakerUsers(ctx, matchMakerUsersEntities)
+	return dc.transaction(ctx, func(ctx context.Context) error {
+		err := dc.repo.UpdateStatusMatchMakerUsers(ctx, matchMakerUsersEntities)
+		if err != nil {
+			return err
+		}
+
+		err = dc.record(ctx, AuditActionPairCall, matchMakerSerial, &AuditState{
+			Status: string(MatchMakerUserStatusRunning),
+			Pair:   matchMakerUserSerial.String(),
+			People: usersRegistered.ToPeople().ToUserReferences(),
+		}, &AuditState{
+			Status: string(MatchMakerUserStatusFinished),
+			Pair:   matchMakerUserSerial.String(),
+			People: people.ToUserReferences(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return dc.publish(ctx, EventTypePairFinished, EventPayload{
+			MatchMakerSerial: matchMakerSerial,
+			Status:           matchMaker.Status,
+			PairSerial:       matchMakerUserSerial.String(),
+			People:           people.ToUserReferences(),
+		})
+	})
 }
 
 func (dc *donutCall) Start(ctx context.Context, matchMakerSerial string) error {
-	matchMaker, err := dc.repo.GetMatchMakerBySerial(ctx, matchMakerSerial)
+	matchMaker, err := dc.getMatchMaker(ctx, matchMakerSerial)
 	if err != nil {
 		return err
 	}
 
 	if matchMaker.Status == MatchMakerStatusRunning {
-		return fmt.Errorf("match maker is already running")
+		return NewAlreadyRunningError(matchMakerSerial)
 	}
 
 	if matchMaker.Status == MatchMakerStatusFinished || matchMaker.Status == MatchMakerStatusStopped {
-		return fmt.Errorf("match maker is already finished or stopped")
+		return NewInvalidTransitionError(matchMakerSerial, matchMaker.Status, "start")
 	}
 
 	return dc.Pair(ctx, matchMakerSerial)
 }
 
 func (dc *donutCall) Stop(ctx context.Context, matchMakerSerial string) error {
-	matchMaker, err := dc.repo.GetMatchMakerBySerial(ctx, matchMakerSerial)
+	matchMaker, err := dc.getMatchMaker(ctx, matchMakerSerial)
 	if err != nil {
 		return err
 	}
@@ -125,53 +227,140 @@ func (dc *donutCall) Stop(ctx context.Context, matchMakerSerial string) error {
 		return err
 	}
 
-	trManagerSettingOptions, err := settings.New(settings.WithPropagation(trm.PropagationRequired))
-	if err != nil {
-		return err
-	}
-
-	trManagerSetting, err := trmgorm.NewSettings(trManagerSettingOptions)
-	if err != nil {
-		return err
-	}
-
-	trManager, err := manager.New(trmgorm.NewDefaultFactory(dc.repo.Database()), manager.WithSettings(trManagerSetting))
-	if err != nil {
-		return err
+	before := &AuditState{
+		Status: string(matchMaker.Status),
+		People: matchMakerUsers.ToPeople().ToUserReferences(),
 	}
 
-	return trManager.Do(ctx, func(ctx context.Context) error {
+	return dc.transaction(ctx, func(ctx context.Context) error {
 		for _, matchMakerUser := range matchMakerUsers {
 			if matchMakerUser == nil {
 				continue
 			}
 			matchMakerUser.Status = MatchMakerUserStatusStopped
-			err := dc.repo.UpdateStatusMatchMakerUser(ctx, matchMakerUser)
-			if err != nil {
-				return err
-			}
 		}
-		return dc.repo.UpdateMatchMakerStatusBySerial(ctx, matchMakerSerial, MatchMakerStatusFinished)
+		err := dc.repo.UpdateStatusMatchMakerUsers(ctx, matchMakerUsers)
+		if err != nil {
+			return err
+		}
+		err = dc.repo.UpdateMatchMakerStatusBySerial(ctx, matchMakerSerial, MatchMakerStatusFinished)
+		if err != nil {
+			return err
+		}
+
+		err = dc.record(ctx, AuditActionMatchMakerStop, matchMakerSerial, before, &AuditState{
+			Status: string(MatchMakerStatusFinished),
+		})
+		if err != nil {
+			return err
+		}
+
+		return dc.publish(ctx, EventTypeMatchMakerStopped, EventPayload{
+			MatchMakerSerial: matchMakerSerial,
+			Status:           MatchMakerStatusFinished,
+		})
 	})
 }
 
+func (dc *donutCall) ListMatchMakers(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error) {
+	ctx = WithReplicaReads(ctx)
+	return dc.repo.GetMatchMakersByStatuses(ctx, statuses)
+}
+
 func (dc *donutCall) GetPeople(ctx context.Context, matchMakerSerial string) (People, error) {
+	ctx = WithReplicaReads(ctx)
 	matchMakerUsers, err := dc.repo.GetUsersByMatchMakerSerial(ctx, matchMakerSerial)
 	return matchMakerUsers.ToPeople(), err
 }
 
 func (dc *donutCall) GetFinishedPeople(ctx context.Context, matchMakerSerial string) (People, error) {
+	ctx = WithReplicaReads(ctx)
 	matchMakerUsers, err := dc.repo.GetUsersByMatchMakerSerialAndStatuses(ctx, matchMakerSerial, []MatchMakerUserStatus{MatchMakerUserStatusFinished})
 	return matchMakerUsers.ToPeople(), err
 }
 
 func (dc *donutCall) GetPendingPeople(ctx context.Context, matchMakerSerial string) (People, error) {
+	ctx = WithReplicaReads(ctx)
 	matchMakerUsers, err := dc.repo.GetUsersByMatchMakerSerialAndStatuses(ctx, matchMakerSerial, []MatchMakerUserStatus{MatchMakerUserStatusPending})
 	return matchMakerUsers.ToPeople(), err
 }
 
 func (dc *donutCall) CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) (string, error) {
-	err := dc.repo.CreateMatchMaker(ctx, matchMaker)
+	if matchMaker == nil {
+		return "", NewValidationError("match maker", "is required")
+	}
+
+	if err := matchMaker.Error(); err != nil {
+		return "", err
+	}
+
+	err := dc.transaction(ctx, func(ctx context.Context) error {
+		err := dc.repo.CreateMatchMaker(ctx, matchMaker)
+		if err != nil {
+			return err
+		}
+
+		err = dc.record(ctx, AuditActionMatchMakerCreate, matchMaker.Serial, nil, &AuditState{
+			Name:   matchMaker.Name,
+			Status: string(matchMaker.Status),
+		})
+		if err != nil {
+			return err
+		}
+
+		return dc.publish(ctx, EventTypeMatchMakerCreated, EventPayload{
+			MatchMakerSerial: matchMaker.Serial,
+			Status:           matchMaker.Status,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return matchMaker.Serial, nil
+}
+
+// ImportMatchMaker creates the match maker and registers the people in a single transaction.
+func (dc *donutCall) ImportMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity, people MatchMakerUserEntities) (string, error) {
+	if err := matchMaker.Error(); err != nil {
+		return "", err
+	}
+
+	for _, person := range people {
+		if person == nil {
+			continue
+		}
+		person.MatchMakerSerial = matchMaker.Serial
+	}
+
+	err := dc.transaction(ctx, func(ctx context.Context) error {
+		err := dc.repo.CreateMatchMaker(ctx, matchMaker)
+		if err != nil {
+			return err
+		}
+
+		if len(people) > 0 {
+			err = dc.repo.CreateMatchMakerUsers(ctx, people)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = dc.record(ctx, AuditActionMatchMakerImport, matchMaker.Serial, nil, &AuditState{
+			Name:   matchMaker.Name,
+			Status: string(matchMaker.Status),
+			People: people.ToPeople().ToUserReferences(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return dc.publish(ctx, EventTypeMatchMakerCreated, EventPayload{
+			MatchMakerSerial: matchMaker.Serial,
+			Status:           matchMaker.Status,
+			People:           people.ToPeople().ToUserReferences(),
+		})
+	})
 	if err != nil {
 		return "", err
 	}
@@ -179,16 +368,196 @@ func (dc *donutCall) CreateMatchMaker(ctx context.Context, matchMaker *MatchMake
 	return matchMaker.Serial, nil
 }
 
+// RegisterPeople registers the people, restoring the ones that unregistered before as if they were new:
+// pending and out of the pair they had.
 func (dc *donutCall) RegisterPeople(ctx context.Context, people MatchMakerUserEntities) error {
-	return dc.repo.CreateMatchMakerUsers(ctx, people)
+	return dc.transaction(ctx, func(ctx context.Context) error {
+		_, err := dc.repo.ReregisterMatchMakerUsers(ctx, people)
+		if err != nil {
+			return err
+		}
+
+		err = dc.repo.CreateMatchMakerUsers(ctx, people)
+		if err != nil {
+			return err
+		}
+
+		err = dc.recordPeople(ctx, AuditActionPeopleRegister, people)
+		if err != nil {
+			return err
+		}
+
+		return dc.publishPeople(ctx, EventTypePeopleRegistered, people)
+	})
 }
 
 func (dc *donutCall) UnRegisterPeople(ctx context.Context, people MatchMakerUserEntities) error {
-	return dc.repo.DeleteMatchMakerUsers(ctx, people)
+	return dc.transaction(ctx, func(ctx context.Context) error {
+		err := dc.repo.DeleteMatchMakerUsers(ctx, people)
+		if err != nil {
+			return err
+		}
+
+		err = dc.recordPeople(ctx, AuditActionPeopleUnregister, people)
+		if err != nil {
+			return err
+		}
+
+		return dc.publishPeople(ctx, EventTypePeopleUnregistered, people)
+	})
+}
+
+// RestorePeople undoes the unregistration of the people and returns how many were restored.
+func (dc *donutCall) RestorePeople(ctx context.Context, people MatchMakerUserEntities) (int64, error) {
+	var restored int64
+	err := dc.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		restored, err = dc.repo.RestoreMatchMakerUsers(ctx, people)
+		if err != nil {
+			return err
+		}
+
+		err = dc.recordPeople(ctx, AuditActionPeopleRestore, people)
+		if err != nil {
+			return err
+		}
+
+		return dc.publishPeople(ctx, EventTypePeopleRestored, people)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return restored, nil
+}
+
+// PurgeDeletedPeople permanently deletes the people unregistered before the given time.
+func (dc *donutCall) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return dc.repo.PurgeDeletedMatchMakerUsers(ctx, deletedBefore)
+}
+
+// ErasePerson replaces the reference of the person with a pseudonym in every match maker and every stored
+// document, and returns the pseudonym with the number of match maker users changed. The rows are kept so that
+// pairs and counts stay valid.
+func (dc *donutCall) ErasePerson(ctx context.Context, reference string) (string, int64, error) {
+	if reference == "" {
+		return "", 0, NewValidationError("reference", "is empty")
+	}
+
+	pseudonym := NewPseudonym()
+
+	var erased int64
+	err := dc.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		erased, err = dc.erasure.PseudonymiseUserReference(ctx, reference, pseudonym)
+		if err != nil {
+			return err
+		}
+
+		return dc.record(ctx, AuditActionPersonErase, "", nil, &AuditState{
+			People: []string{pseudonym},
+		})
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return pseudonym, erased, nil
+}
+
+// ArchiveMatchMakers moves the match makers finished or stopped before the given time to the archive,
+// each one in its own transaction, and returns how many were archived.
+func (dc *donutCall) ArchiveMatchMakers(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	var archived int64
+	statuses := []MatchMakerStatus{MatchMakerStatusFinished, MatchMakerStatusStopped}
+
+	for {
+		serials, err := dc.repo.GetMatchMakerSerialsByStatusesUpdatedBefore(ctx, statuses, finishedBefore, archiveBatchSize)
+		if err != nil {
+			return archived, err
+		}
+
+		for _, serial := range serials {
+			err := dc.transaction(ctx, func(ctx context.Context) error {
+				err := dc.repo.ArchiveMatchMaker(ctx, serial)
+				if err != nil {
+					return err
+				}
+
+				return dc.record(ctx, AuditActionMatchMakerArchive, serial, nil, nil)
+			})
+			if err != nil {
+				return archived, err
+			}
+			archived++
+		}
+
+		if len(serials) < archiveBatchSize {
+			return archived, nil
+		}
+	}
+}
+
+// publishPeople appends one event per match maker of the people.
+func (dc *donutCall) publishPeople(ctx context.Context, eventType EventType, people MatchMakerUserEntities) error {
+	matchMakerSerials, references := groupPeopleByMatchMaker(people)
+
+	for _, matchMakerSerial := range matchMakerSerials {
+		err := dc.publish(ctx, eventType, EventPayload{
+			MatchMakerSerial: matchMakerSerial,
+			People:           references[matchMakerSerial],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordPeople appends one audit entry per match maker of the people, registered people are stored
+// as the state after the action and unregistered people as the state before it.
+func (dc *donutCall) recordPeople(ctx context.Context, action AuditAction, people MatchMakerUserEntities) error {
+	matchMakerSerials, references := groupPeopleByMatchMaker(people)
+
+	for _, matchMakerSerial := range matchMakerSerials {
+		state := &AuditState{People: references[matchMakerSerial]}
+
+		var err error
+		if action == AuditActionPeopleUnregister {
+			err = dc.record(ctx, action, matchMakerSerial, state, nil)
+		} else {
+			err = dc.record(ctx, action, matchMakerSerial, nil, state)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupPeopleByMatchMaker returns the match maker serials in order of first appearance and the references of their people.
+func groupPeopleByMatchMaker(people MatchMakerUserEntities) ([]string, map[string][]string) {
+	references := make(map[string][]string)
+	matchMakerSerials := make([]string, 0)
+
+	for _, person := range people {
+		if person == nil {
+			continue
+		}
+		if _, ok := references[person.MatchMakerSerial]; !ok {
+			matchMakerSerials = append(matchMakerSerials, person.MatchMakerSerial)
+		}
+		references[person.MatchMakerSerial] = append(references[person.MatchMakerSerial], person.UserReference)
+	}
+
+	return matchMakerSerials, references
 }
 
 func (dc *donutCall) GetInformation(ctx context.Context, matchMakerSerial string) (*MatchMakerInformation, error) {
-	matchMaker, err := dc.repo.GetMatchMakerBySerial(ctx, matchMakerSerial)
+	ctx = WithReplicaReads(ctx)
+	matchMaker, err := dc.getMatchMaker(ctx, matchMakerSerial)
 	if err != nil {
 		return nil, err
 	}
@@ -201,10 +570,13 @@ func (dc *donutCall) GetInformation(ctx context.Context, matchMakerSerial string
 	return &MatchMakerInformation{
 		MatchMaker: matchMaker,
 		Users:      matchMakerUsers,
+		Pairs:      matchMakerUsers.ToMatchMap(),
 	}, nil
 }
 
 func (dc *donutCall) Pair(ctx context.Context, matchMakerSerial string) error {
+	startTime := time.Now()
+
 	matchMakerUsers, err := dc.repo.GetUsersByMatchMakerSerialAndStatuses(ctx, matchMakerSerial, []MatchMakerUserStatus{MatchMakerUserStatusPending})
 	if err != nil {
 		return err
@@ -232,36 +604,48 @@ func (dc *donutCall) Pair(ctx context.Context, matchMakerSerial string) error {
 		}
 	}
 
-	trManagerSettingOptions, err := settings.New(settings.WithPropagation(trm.PropagationRequired))
-	if err != nil {
-		return err
-	}
+	err = dc.transaction(ctx, func(ctx context.Context) error {
+		// Claim the match maker first, a concurrent start blocks on the row until this transaction ends
+		// and then finds it running.
+		swapped, err := dc.repo.SwapMatchMakerStatusBySerial(ctx, matchMakerSerial, MatchMakerStatusPending, MatchMakerStatusRunning)
+		if err != nil {
+			return err
+		}
+		if !swapped {
+			return ErrMatchMakerConcurrentUpdate
+		}
 
-	trManagerSetting, err := trmgorm.NewSettings(trManagerSettingOptions)
-	if err != nil {
-		return err
-	}
+		err = dc.repo.UpdateSerialMatchMakerUsers(ctx, matchMakerUsersEntities)
+		if err != nil {
+			return err
+		}
 
-	trManager, err := manager.New(trmgorm.NewDefaultFactory(dc.repo.Database()), manager.WithSettings(trManagerSetting))
+		err = dc.record(ctx, AuditActionMatchMakerStart, matchMakerSerial, &AuditState{
+			Status: string(MatchMakerStatusPending),
+			People: matchMakerPeople.ToUserReferences(),
+		}, &AuditState{
+			Status: string(MatchMakerStatusRunning),
+			People: matchMakerUsersEntities.ToPeople().ToUserReferences(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return dc.publish(ctx, EventTypeMatchMakerStarted, EventPayload{
+			MatchMakerSerial: matchMakerSerial,
+			Status:           MatchMakerStatusRunning,
+		})
+	})
 	if err != nil {
 		return err
 	}
 
-	return trManager.Do(ctx, func(ctx context.Context) error {
-		for _, matchMakerUser := range matchMakerUsersEntities {
-			if matchMakerUser == nil {
-				continue
-			}
-			err := dc.repo.UpdateSerialMatchMakerUser(ctx, matchMakerUser)
-			if err != nil {
-				return err
-			}
-		}
-		return dc.repo.UpdateMatchMakerStatusBySerial(ctx, matchMakerSerial, MatchMakerStatusRunning)
-	})
+	dc.metrics.ObservePairing(startTime, matchMakerUsersEntities.ToMatchMap())
+	return nil
 }
 
 func (dc *donutCall) GetPeoplePair(ctx context.Context, matchMakerSerial string) (MatchMap, error) {
+	ctx = WithReplicaReads(ctx)
 	matchMakerUsers, err := dc.repo.GetUsersByMatchMakerSerial(ctx, matchMakerSerial)
 	if err != nil {
 		return nil, err
@@ -270,6 +654,34 @@ func (dc *donutCall) GetPeoplePair(ctx context.Context, matchMakerSerial string)
 	return matchMakerUsers.ToMatchMap(), nil
 }
 
+// GetPairInformation returns the match maker of a pair together with the people in that pair only.
+func (dc *donutCall) GetPairInformation(ctx context.Context, pairSerial string) (*MatchMakerInformation, error) {
+	ctx = WithReplicaReads(ctx)
+	if pairSerial == "" {
+		return nil, NewValidationError("pair serial", "is empty")
+	}
+
+	matchMakerUsers, err := dc.repo.GetUsersBySerial(ctx, pairSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matchMakerUsers) == 0 || matchMakerUsers[0] == nil {
+		return nil, NewNotFoundError("pair", pairSerial)
+	}
+
+	matchMaker, err := dc.getMatchMaker(ctx, matchMakerUsers[0].MatchMakerSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MatchMakerInformation{
+		MatchMaker: matchMaker,
+		Users:      matchMakerUsers,
+		Pairs:      matchMakerUsers.ToMatchMap(),
+	}, nil
+}
+
 func processOneWayCall(people People) People {
 	fmt.Printf("There is one person left: %s\n", people[0].Name)
 	people = people[:0]
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	"gorm.io/gorm"
+)
+
+func newTestDonutCall(db *gorm.DB) (DonutCall, DonutRepository) {
+	repo := NewDonutRepository(db)
+	return NewDonutCall(repo, NewOutboxRepository(db), NewAuditRepository(db), NewErasureRepository(db), nil), repo
+}
+
+// TestPairConcurrently starts the same match maker from several goroutines at once, which all get past the
+// status check of Start: exactly one pairs the people and the others are aborted without writing anything.
+func TestPairConcurrently(t *testing.T) {
+	const (
+		starts = 8
+		people = 100
+	)
+
+	dc, repo := newTestDonutCall(openTestDatabase(t))
+	ctx := context.Background()
+	matchMaker, _ := createTestMatchMaker(t, repo, people)
+
+	errs := make(chan error, starts)
+	ready := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < starts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ready
+			errs <- dc.Pair(ctx, matchMaker.Serial)
+		}()
+	}
+	close(ready)
+	wg.Wait()
+	close(errs)
+
+	var succeeded int
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if code := connect.CodeOf(ToConnectError(err)); code != connect.CodeAborted {
+			t.Errorf("expected %s for a concurrent start but got %s: %v", connect.CodeAborted, code, err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one start to succeed but %d did", succeeded)
+	}
+
+	got, err := repo.GetMatchMakerBySerial(ctx, matchMaker.Serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != MatchMakerStatusRunning {
+		t.Errorf("expected the match maker to be %s but it is %s", MatchMakerStatusRunning, got.Status)
+	}
+
+	// A single pairing leaves every person in exactly one group of two
+	matchMakerUsers, err := repo.GetUsersByMatchMakerSerial(ctx, matchMaker.Serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := make(map[string]int)
+	for _, matchMakerUser := range matchMakerUsers {
+		if matchMakerUser.Status != MatchMakerUserStatusRunning {
+			t.Errorf("expected %s to be %s but it is %s", matchMakerUser.UserReference, MatchMakerUserStatusRunning, matchMakerUser.Status)
+		}
+		groups[matchMakerUser.Serial]++
+	}
+	if len(groups) != people/2 {
+		t.Errorf("expected %d groups but got %d", people/2, len(groups))
+	}
+	for serial, size := range groups {
+		if size != 2 {
+			t.Errorf("expected group %s to have 2 people but it has %d", serial, size)
+		}
+	}
+}
+
+// TestRegisterPeopleAgain unregisters a paired person and registers them again, they come back pending and
+// out of their former pair.
+func TestRegisterPeopleAgain(t *testing.T) {
+	dc, repo := newTestDonutCall(openTestDatabase(t))
+	ctx := context.Background()
+	matchMaker, matchMakerUsers := createTestMatchMaker(t, repo, 2)
+
+	if err := dc.Pair(ctx, matchMaker.Serial); err != nil {
+		t.Fatal(err)
+	}
+
+	person := (&MatchMakerUserEntity{}).Build(
+		WithMatchMakerUserEntityMatchMakerSerial(matchMaker.Serial),
+		WithMatchMakerUserEntityUserReference(matchMakerUsers[0].UserReference),
+	)
+	if err := dc.UnRegisterPeople(ctx, MatchMakerUserEntities{person}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.RegisterPeople(ctx, MatchMakerUserEntities{person}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetUsersByMatchMakerSerialAndUserReferences(ctx, matchMaker.Serial, []string{person.UserReference})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected the person to be registered once but got %d rows", len(got))
+	}
+	if got[0].Status != MatchMakerUserStatusPending || got[0].Serial != "" {
+		t.Errorf("expected the person to be pending without a pair but got %s in %q", got[0].Status, got[0].Serial)
+	}
+}
+
+func BenchmarkPair(b *testing.B) {
+	dc, repo := newTestDonutCall(openTestDatabase(b))
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		matchMaker, _ := createTestMatchMaker(b, repo, benchmarkParticipants)
+		b.StartTimer()
+
+		if err := dc.Pair(ctx, matchMaker.Serial); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
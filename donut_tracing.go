@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const matchMakerSerialAttribute = attribute.Key("donut.matchmaker.serial")
+
+// tracedDonutCall wraps a DonutCall with a span per method, the repository spans of the call become its children.
+type tracedDonutCall struct {
+	next DonutCall
+}
+
+func NewTracedDonutCall(next DonutCall) DonutCall {
+	return &tracedDonutCall{
+		next: next,
+	}
+}
+
+func (t *tracedDonutCall) start(ctx context.Context, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "DonutCall."+method, trace.WithAttributes(attributes...))
+}
+
+func (t *tracedDonutCall) Start(ctx context.Context, matchMakerSerial string) (err error) {
+	ctx, span := t.start(ctx, "Start", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.Start(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) Stop(ctx context.Context, matchMakerSerial string) (err error) {
+	ctx, span := t.start(ctx, "Stop", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.Stop(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) Pair(ctx context.Context, matchMakerSerial string) (err error) {
+	ctx, span := t.start(ctx, "Pair", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.Pair(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) Call(ctx context.Context, matchMakerSerial string, people People) (err error) {
+	ctx, span := t.start(ctx, "Call", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.Call(ctx, matchMakerSerial, people)
+}
+
+func (t *tracedDonutCall) CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) (serial string, err error) {
+	ctx, span := t.start(ctx, "CreateMatchMaker")
+	defer func() {
+		span.SetAttributes(matchMakerSerialAttribute.String(serial))
+		endSpan(span, err)
+	}()
+
+	return t.next.CreateMatchMaker(ctx, matchMaker)
+}
+
+func (t *tracedDonutCall) ImportMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity, people MatchMakerUserEntities) (serial string, err error) {
+	ctx, span := t.start(ctx, "ImportMatchMaker", attribute.Int("donut.people.count", len(people)))
+	defer func() {
+		span.SetAttributes(matchMakerSerialAttribute.String(serial))
+		endSpan(span, err)
+	}()
+
+	return t.next.ImportMatchMaker(ctx, matchMaker, people)
+}
+
+func (t *tracedDonutCall) GetInformation(ctx context.Context, matchMakerSerial string) (_ *MatchMakerInformation, err error) {
+	ctx, span := t.start(ctx, "GetInformation", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetInformation(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) ListMatchMakers(ctx context.Context, statuses []MatchMakerStatus) (_ MatchMakerEntities, err error) {
+	ctx, span := t.start(ctx, "ListMatchMakers")
+	defer func() { endSpan(span, err) }()
+
+	return t.next.ListMatchMakers(ctx, statuses)
+}
+
+func (t *tracedDonutCall) GetPeople(ctx context.Context, matchMakerSerial string) (_ People, err error) {
+	ctx, span := t.start(ctx, "GetPeople", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetPeople(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) GetFinishedPeople(ctx context.Context, matchMakerSerial string) (_ People, err error) {
+	ctx, span := t.start(ctx, "GetFinishedPeople", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetFinishedPeople(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) GetPendingPeople(ctx context.Context, matchMakerSerial string) (_ People, err error) {
+	ctx, span := t.start(ctx, "GetPendingPeople", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetPendingPeople(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) GetPeoplePair(ctx context.Context, matchMakerSerial string) (_ MatchMap, err error) {
+	ctx, span := t.start(ctx, "GetPeoplePair", matchMakerSerialAttribute.String(matchMakerSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetPeoplePair(ctx, matchMakerSerial)
+}
+
+func (t *tracedDonutCall) GetPairInformation(ctx context.Context, pairSerial string) (_ *MatchMakerInformation, err error) {
+	ctx, span := t.start(ctx, "GetPairInformation", attribute.String("donut.pair.serial", pairSerial))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.GetPairInformation(ctx, pairSerial)
+}
+
+func (t *tracedDonutCall) RegisterPeople(ctx context.Context, people MatchMakerUserEntities) (err error) {
+	ctx, span := t.start(ctx, "RegisterPeople", attribute.Int("donut.people.count", len(people)))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.RegisterPeople(ctx, people)
+}
+
+func (t *tracedDonutCall) UnRegisterPeople(ctx context.Context, people MatchMakerUserEntities) (err error) {
+	ctx, span := t.start(ctx, "UnRegisterPeople", attribute.Int("donut.people.count", len(people)))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.UnRegisterPeople(ctx, people)
+}
+
+func (t *tracedDonutCall) RestorePeople(ctx context.Context, people MatchMakerUserEntities) (_ int64, err error) {
+	ctx, span := t.start(ctx, "RestorePeople", attribute.Int("donut.people.count", len(people)))
+	defer func() { endSpan(span, err) }()
+
+	return t.next.RestorePeople(ctx, people)
+}
+
+func (t *tracedDonutCall) PurgeDeletedPeople(ctx context.Context, deletedBefore time.Time) (_ int64, err error) {
+	ctx, span := t.start(ctx, "PurgeDeletedPeople")
+	defer func() { endSpan(span, err) }()
+
+	return t.next.PurgeDeletedPeople(ctx, deletedBefore)
+}
+
+func (t *tracedDonutCall) ErasePerson(ctx context.Context, reference string) (_ string, _ int64, err error) {
+	ctx, span := t.start(ctx, "ErasePerson")
+	defer func() { endSpan(span, err) }()
+
+	return t.next.ErasePerson(ctx, reference)
+}
+
+func (t *tracedDonutCall) ArchiveMatchMakers(ctx context.Context, finishedBefore time.Time) (_ int64, err error) {
+	ctx, span := t.start(ctx, "ArchiveMatchMakers")
+	defer func() { endSpan(span, err) }()
+
+	return t.next.ArchiveMatchMakers(ctx, finishedBefore)
+}
@@ -1,12 +1,19 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 const (
 	MatchMakerSerialColumn = "matchmaker_serial"
 	UserReferenceColumn    = "user_reference"
 	SerialColumn           = "serial"
 	StatusColumn           = "status"
+	DeletedAtColumn        = "deleted_at"
+	UpdatedAtColumn        = "updated_at"
+	StartTimeColumn        = "start_time"
 )
 
 type MatchMaker struct {
@@ -34,8 +41,8 @@ func (MatchMaker) FromEntity(entity *MatchMakerEntity) *MatchMaker {
 		Name:        entity.Name,
 		Description: entity.Description,
 		Status:      entity.Status,
-		StartTime:   entity.StartTime,
-		EndTime:     entity.StartTime.Add(entity.Duration * Day),
+		StartTime:   entity.StartTime.UTC(),
+		EndTime:     entity.StartTime.Add(entity.Duration * Day).UTC(),
 	}
 }
 
@@ -48,18 +55,31 @@ func (m *MatchMaker) ToEntity() *MatchMakerEntity {
 		Serial:      m.Serial,
 		Name:        m.Name,
 		Description: m.Description,
-		StartTime:   m.StartTime,
+		StartTime:   m.StartTime.UTC(),
 		Duration:    m.EndTime.Sub(m.StartTime),
 		Status:      m.Status,
 	}
 }
 
+type MatchMakers []*MatchMaker
+
+func (m MatchMakers) ToEntities() MatchMakerEntities {
+	var entities MatchMakerEntities
+	for _, matchMaker := range m {
+		if matchMaker == nil {
+			continue
+		}
+		entities = append(entities, matchMaker.ToEntity())
+	}
+	return entities
+}
+
 type MatchMakerUser struct {
 	MatchMakerSerial string `gorm:"column:matchmaker_serial"`
 	Serial           string `gorm:"uniqueIndex"`
 	UserReference    string
 	Status           MatchMakerUserStatus
-	DeletedAt        *time.Time
+	DeletedAt        gorm.DeletedAt
 	CreatedAt        time.Time `gorm:"autoCreateTime"`
 	UpdatedAt        time.Time `gorm:"autoUpdateTime"`
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"time"
 )
 
@@ -73,6 +75,10 @@ func (m MatchMap) First() (MatchMakerUserSerial, People) {
 	return "", nil
 }
 
+// maxMatchMakerDurationDays is the longest duration of a match maker, its end time overflows past it.
+const maxMatchMakerDurationDays = time.Duration(math.MaxInt64 / int64(Day))
+
+// MatchMakerEntity is a match maker, its duration is in days until it is stored, see MatchMaker.FromEntity.
 type MatchMakerEntity struct {
 	Serial      string
 	Name        string
@@ -82,6 +88,8 @@ type MatchMakerEntity struct {
 	Duration    time.Duration
 }
 
+type MatchMakerEntities []*MatchMakerEntity
+
 type MatchMakerEntityOption func(*MatchMakerEntity)
 
 func WithMatchMakerEntityName(name string) MatchMakerEntityOption {
@@ -121,9 +129,10 @@ func (m *MatchMakerEntity) Build(options ...MatchMakerEntityOption) *MatchMakerE
 	}
 
 	if m.Duration == 0 {
-		m.Duration = 24 * time.Hour
+		m.Duration = 1
 	}
 
+	m.Name = strings.TrimSpace(m.Name)
 	if m.Name == "" {
 		m.Name = fmt.Sprintf("MatchMaker-%s", m.Serial)
 	}
@@ -133,19 +142,19 @@ func (m *MatchMakerEntity) Build(options ...MatchMakerEntityOption) *MatchMakerE
 
 func (m *MatchMakerEntity) Error() error {
 	if m.Serial == "" {
-		return fmt.Errorf("serial is empty")
+		return NewValidationError("serial", "is empty")
 	}
 
-	if m.Name == "" {
-		return fmt.Errorf("name is empty")
+	if strings.TrimSpace(m.Name) == "" {
+		return NewValidationError("name", "is blank")
 	}
 
 	if m.StartTime.IsZero() {
-		return fmt.Errorf("start time is zero")
+		return NewValidationError("start time", "is zero")
 	}
 
-	if m.Duration == 0 {
-		return fmt.Errorf("duration is zero")
+	if m.Duration < 1 || m.Duration > maxMatchMakerDurationDays {
+		return NewValidationError("duration", fmt.Sprintf("must be between 1 and %d days", maxMatchMakerDurationDays))
 	}
 
 	return nil
@@ -198,15 +207,15 @@ func (m *MatchMakerUserEntity) Build(options ...MatchMakerUserEntityOption) *Mat
 
 func (m *MatchMakerUserEntity) Error() error {
 	if m.MatchMakerSerial == "" {
-		return fmt.Errorf("match maker serial is empty")
+		return NewValidationError("match maker serial", "is empty")
 	}
 
 	if m.UserReference == "" {
-		return fmt.Errorf("user reference is empty")
+		return NewValidationError("user reference", "is empty")
 	}
 
 	if m.Serial == "" {
-		return fmt.Errorf("serial is empty")
+		return NewValidationError("serial", "is empty")
 	}
 
 	return nil
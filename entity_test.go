@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchMakerEntityBuild(t *testing.T) {
+	matchMaker := (&MatchMakerEntity{}).Build(WithMatchMakerEntityName("  "))
+
+	if matchMaker.Duration != 1 {
+		t.Errorf("expected a default duration of 1 day but got %d", matchMaker.Duration)
+	}
+	if !strings.HasPrefix(matchMaker.Name, "MatchMaker-") {
+		t.Errorf("expected the default name of a blank name but got %q", matchMaker.Name)
+	}
+	if err := matchMaker.Error(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMatchMakerEntityError(t *testing.T) {
+	tests := []struct {
+		name       string
+		matchMaker *MatchMakerEntity
+		valid      bool
+	}{
+		{"one day", &MatchMakerEntity{Duration: 1}, true},
+		{"longest", &MatchMakerEntity{Duration: maxMatchMakerDurationDays}, true},
+		{"no duration", &MatchMakerEntity{Duration: 0}, false},
+		{"negative duration", &MatchMakerEntity{Duration: -7}, false},
+		{"overflowing duration", &MatchMakerEntity{Duration: maxMatchMakerDurationDays + 1}, false},
+		{"blank name", &MatchMakerEntity{Name: " \t", Duration: 1}, false},
+	}
+
+	for _, test := range tests {
+		matchMaker := test.matchMaker
+		matchMaker.Serial = GenerateSerial()
+		matchMaker.StartTime = time.Now()
+		if matchMaker.Name == "" {
+			matchMaker.Name = "Coffee"
+		}
+
+		err := matchMaker.Error()
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %v but got %v", test.name, test.valid, err)
+		}
+		if err != nil && !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: expected a validation error but got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PayloadColumn = "payload"
+	BeforeColumn  = "before"
+	AfterColumn   = "after"
+
+	pseudonymPrefix = "erased-"
+)
+
+// NewPseudonym returns the reference that replaces an erased one, it is shared by every row of the person
+// so pairs and counts stay consistent while nothing links it back to the person.
+func NewPseudonym() string {
+	return pseudonymPrefix + GenerateSerial()
+}
+
+type erasureRepository struct {
+	db *gorm.DB
+}
+
+// ErasureRepository replaces a user reference everywhere the service stored it.
+type ErasureRepository interface {
+	PseudonymiseUserReference(ctx context.Context, reference, pseudonym string) (int64, error)
+}
+
+func NewErasureRepository(db *gorm.DB) ErasureRepository {
+	return &erasureRepository{
+		db: db,
+	}
+}
+
+// PseudonymiseUserReference replaces the reference in the match maker users, including the unregistered
+// and archived ones, and in the JSON documents of the audit log, the outbox, the webhook deliveries and dead letters.
+// It returns how many match maker users were changed.
+func (r *erasureRepository) PseudonymiseUserReference(ctx context.Context, reference, pseudonym string) (int64, error) {
+	var erased int64
+	err := transactionOrDB(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		q := fmt.Sprintf("%s = ?", UserReferenceColumn)
+
+		result := tx.Unscoped().Model(&MatchMakerUser{}).Where(q, reference).Update(UserReferenceColumn, pseudonym)
+		if result.Error != nil {
+			return result.Error
+		}
+		erased = result.RowsAffected
+
+		result = tx.Model(&MatchMakerUserArchive{}).Where(q, reference).Update(UserReferenceColumn, pseudonym)
+		if result.Error != nil {
+			return result.Error
+		}
+		erased += result.RowsAffected
+
+		documents := []struct {
+			model  interface{}
+			column string
+		}{
+			{&AuditLog{}, BeforeColumn},
+			{&AuditLog{}, AfterColumn},
+			{&OutboxEvent{}, PayloadColumn},
+			{&WebhookDelivery{}, PayloadColumn},
+			{&WebhookDeadLetter{}, PayloadColumn},
+		}
+
+		for _, document := range documents {
+			if err := redactJSONString(tx, document.model, document.column, reference, pseudonym); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	return erased, err
+}
+
+// redactJSONString replaces the JSON string of value with the one of replacement in a text column holding JSON.
+func redactJSONString(tx *gorm.DB, model interface{}, column, value, replacement string) error {
+	from, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	to, err := json.Marshal(replacement)
+	if err != nil {
+		return err
+	}
+
+	quoted := tx.Statement.Quote(column)
+	return tx.Model(model).
+		Where(fmt.Sprintf("%s LIKE ?", quoted), "%"+escapeLike(string(from))+"%").
+		Update(column, gorm.Expr(fmt.Sprintf("REPLACE(%s, ?, ?)", quoted), string(from), string(to))).
+		Error
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// ErrorDomain is the domain of the ErrorInfo detail attached to the errors sent to clients.
+const ErrorDomain = "donut.mocha.bot"
+
+// The kinds of domain errors, check them with errors.Is.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrAlreadyRunning    = errors.New("already running")
+	ErrInvalidTransition = errors.New("invalid transition")
+	ErrIncompletePair    = errors.New("incomplete pair")
+	ErrValidation        = errors.New("validation failed")
+)
+
+// DomainError is an error of the service, its kind tells the client what went wrong and the other fields
+// which resource or field it is about.
+type DomainError struct {
+	Kind     error
+	Resource string
+	Serial   string
+	Field    string
+	Status   MatchMakerStatus
+	Message  string
+}
+
+func (e *DomainError) Error() string {
+	return e.Message
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.Kind
+}
+
+func NewNotFoundError(resource, serial string) error {
+	return &DomainError{
+		Kind:     ErrNotFound,
+		Resource: resource,
+		Serial:   serial,
+		Message:  fmt.Sprintf("%s %s is not found", resource, serial),
+	}
+}
+
+func NewAlreadyRunningError(matchMakerSerial string) error {
+	return &DomainError{
+		Kind:     ErrAlreadyRunning,
+		Resource: "match maker",
+		Serial:   matchMakerSerial,
+		Status:   MatchMakerStatusRunning,
+		Message:  fmt.Sprintf("match maker %s is already running", matchMakerSerial),
+	}
+}
+
+// NewInvalidTransitionError tells that the action isn't allowed while the match maker has the given status.
+func NewInvalidTransitionError(matchMakerSerial string, status MatchMakerStatus, action string) error {
+	return &DomainError{
+		Kind:     ErrInvalidTransition,
+		Resource: "match maker",
+		Serial:   matchMakerSerial,
+		Status:   status,
+		Message:  fmt.Sprintf("cannot %s match maker %s while it is %s", action, matchMakerSerial, status),
+	}
+}
+
+func NewIncompletePairError(pairSerial, message string) error {
+	return &DomainError{
+		Kind:     ErrIncompletePair,
+		Resource: "pair",
+		Serial:   pairSerial,
+		Message:  message,
+	}
+}
+
+// NewValidationError tells that the field is invalid, the message completes the field name, such as "is empty".
+func NewValidationError(field, message string) error {
+	return &DomainError{
+		Kind:    ErrValidation,
+		Field:   field,
+		Message: fmt.Sprintf("%s %s", field, message),
+	}
+}
+
+// errorReasons are the reasons of the ErrorInfo detail by kind.
+var errorReasons = map[error]string{
+	ErrNotFound:                   "NOT_FOUND",
+	ErrAlreadyRunning:             "ALREADY_RUNNING",
+	ErrInvalidTransition:          "INVALID_TRANSITION",
+	ErrIncompletePair:             "INCOMPLETE_PAIR",
+	ErrValidation:                 "VALIDATION",
+	ErrMatchMakerConcurrentUpdate: "CONCURRENT_UPDATE",
+	ErrRateLimited:                "RATE_LIMITED",
+	ErrStreamExhausted:            "STREAM_EXHAUSTED",
+}
+
+// ToConnectError translates a domain error into a connect error with its code and structured details,
+// the other errors are returned unchanged.
+func ToConnectError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return err
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return connect.NewError(connect.CodeNotFound, err)
+	}
+
+	if errors.Is(err, ErrMatchMakerConcurrentUpdate) {
+		return newConnectError(connect.CodeAborted, err, errorReasons[ErrMatchMakerConcurrentUpdate], nil)
+	}
+
+	var domainErr *DomainError
+	if !errors.As(err, &domainErr) {
+		return err
+	}
+
+	reason := errorReasons[domainErr.Kind]
+	metadata := map[string]string{}
+	if domainErr.Serial != "" {
+		metadata["serial"] = domainErr.Serial
+	}
+	if domainErr.Status != "" {
+		metadata["status"] = string(domainErr.Status)
+	}
+
+	switch domainErr.Kind {
+	case ErrNotFound:
+		return newConnectError(connect.CodeNotFound, err, reason, metadata, &errdetails.ResourceInfo{
+			ResourceType: domainErr.Resource,
+			ResourceName: domainErr.Serial,
+			Description:  domainErr.Message,
+		})
+	case ErrValidation:
+		return newConnectError(connect.CodeInvalidArgument, err, reason, metadata, &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: domainErr.Field, Description: domainErr.Message},
+			},
+		})
+	case ErrAlreadyRunning, ErrInvalidTransition, ErrIncompletePair:
+		return newConnectError(connect.CodeFailedPrecondition, err, reason, metadata, &errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{
+				{Type: reason, Subject: domainErr.Serial, Description: domainErr.Message},
+			},
+		})
+	default:
+		return err
+	}
+}
+
+// newConnectError attaches an ErrorInfo detail with the reason and metadata, followed by the other details.
+func newConnectError(code connect.Code, err error, reason string, metadata map[string]string, details ...proto.Message) *connect.Error {
+	connectErr := connect.NewError(code, err)
+
+	messages := append([]proto.Message{&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	}}, details...)
+
+	for _, message := range messages {
+		detail, detailErr := connect.NewErrorDetail(message)
+		if detailErr != nil {
+			continue
+		}
+		connectErr.AddDetail(detail)
+	}
+
+	return connectErr
+}
+
+// HTTPStatus returns the status of the plain HTTP endpoints for an error of the service.
+func HTTPStatus(err error) int {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		switch connectErr.Code() {
+		case connect.CodeInvalidArgument:
+			return http.StatusBadRequest
+		case connect.CodeNotFound:
+			return http.StatusNotFound
+		case connect.CodeFailedPrecondition, connect.CodeAborted:
+			return http.StatusConflict
+		case connect.CodeResourceExhausted:
+			return http.StatusTooManyRequests
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAlreadyRunning), errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrIncompletePair), errors.Is(err, ErrMatchMakerConcurrentUpdate):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ConnectCode is the reverse of HTTPStatus, it returns the code of an HTTP status.
+func ConnectCode(status int) connect.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return connect.CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return connect.CodeUnauthenticated
+	case http.StatusForbidden:
+		return connect.CodePermissionDenied
+	case http.StatusNotFound:
+		return connect.CodeNotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return connect.CodeUnimplemented
+	case http.StatusConflict:
+		return connect.CodeFailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return connect.CodeResourceExhausted
+	case http.StatusServiceUnavailable:
+		return connect.CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return connect.CodeDeadlineExceeded
+	}
+
+	if status >= http.StatusInternalServerError {
+		return connect.CodeInternal
+	}
+	return connect.CodeUnknown
+}
+
+// ErrorMessage returns the message of the error without the code prefix of a connect error,
+// for the HTTP endpoints whose status already tells it.
+func ErrorMessage(err error) string {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return connectErr.Message()
+	}
+	return err.Error()
+}
+
+type errorInterceptor struct{}
+
+// NewErrorInterceptor translates the errors returned by the handlers with ToConnectError, so clients get
+// a meaningful code instead of Unknown.
+func NewErrorInterceptor() connect.Interceptor {
+	return &errorInterceptor{}
+}
+
+func (i *errorInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		resp, err := next(ctx, req)
+		if err != nil {
+			return nil, ToConnectError(err)
+		}
+		return resp, nil
+	}
+}
+
+func (i *errorInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *errorInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		return ToConnectError(next(ctx, conn))
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+func TestToConnectError(t *testing.T) {
+	serial := GenerateSerial()
+
+	tests := []struct {
+		name    string
+		err     error
+		code    connect.Code
+		status  int
+		reason  string
+		details func(t *testing.T, details []proto.Message)
+	}{
+		{
+			name:   "not found",
+			err:    NewNotFoundError("match maker", serial),
+			code:   connect.CodeNotFound,
+			status: http.StatusNotFound,
+			reason: "NOT_FOUND",
+			details: func(t *testing.T, details []proto.Message) {
+				info, ok := details[1].(*errdetails.ResourceInfo)
+				if !ok || info.GetResourceType() != "match maker" || info.GetResourceName() != serial {
+					t.Errorf("expected a ResourceInfo of match maker %s but got %v", serial, details[1])
+				}
+			},
+		},
+		{
+			name:   "validation",
+			err:    NewValidationError("name", "is blank"),
+			code:   connect.CodeInvalidArgument,
+			status: http.StatusBadRequest,
+			reason: "VALIDATION",
+			details: func(t *testing.T, details []proto.Message) {
+				badRequest, ok := details[1].(*errdetails.BadRequest)
+				if !ok || len(badRequest.GetFieldViolations()) != 1 || badRequest.GetFieldViolations()[0].GetField() != "name" {
+					t.Errorf("expected a BadRequest of the name but got %v", details[1])
+				}
+			},
+		},
+		{
+			name:    "already running",
+			err:     NewAlreadyRunningError(serial),
+			code:    connect.CodeFailedPrecondition,
+			status:  http.StatusConflict,
+			reason:  "ALREADY_RUNNING",
+			details: expectPreconditionFailure("ALREADY_RUNNING", serial),
+		},
+		{
+			name:    "invalid transition",
+			err:     NewInvalidTransitionError(serial, MatchMakerStatusFinished, "start"),
+			code:    connect.CodeFailedPrecondition,
+			status:  http.StatusConflict,
+			reason:  "INVALID_TRANSITION",
+			details: expectPreconditionFailure("INVALID_TRANSITION", serial),
+		},
+		{
+			name:    "incomplete pair",
+			err:     NewIncompletePairError(serial, "pair has a single person"),
+			code:    connect.CodeFailedPrecondition,
+			status:  http.StatusConflict,
+			reason:  "INCOMPLETE_PAIR",
+			details: expectPreconditionFailure("INCOMPLETE_PAIR", serial),
+		},
+		{
+			name:   "wrapped domain error",
+			err:    fmt.Errorf("start: %w", NewNotFoundError("match maker", serial)),
+			code:   connect.CodeNotFound,
+			status: http.StatusNotFound,
+			reason: "NOT_FOUND",
+		},
+		{
+			name:   "record not found",
+			err:    gorm.ErrRecordNotFound,
+			code:   connect.CodeNotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "concurrent update",
+			err:    fmt.Errorf("stop: %w", ErrMatchMakerConcurrentUpdate),
+			code:   connect.CodeAborted,
+			status: http.StatusConflict,
+			reason: "CONCURRENT_UPDATE",
+		},
+		{
+			name:   "connect error",
+			err:    connect.NewError(connect.CodeResourceExhausted, errors.New("too many requests")),
+			code:   connect.CodeResourceExhausted,
+			status: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, test := range tests {
+		err := ToConnectError(test.err)
+
+		if status := HTTPStatus(test.err); status != test.status {
+			t.Errorf("%s: expected the status %d but got %d", test.name, test.status, status)
+		}
+
+		code, details := connectErrorDetails(t, err)
+		if code != test.code {
+			t.Errorf("%s: expected the code %s but got %s", test.name, test.code, code)
+		}
+
+		if test.reason == "" {
+			continue
+		}
+		info, ok := details[0].(*errdetails.ErrorInfo)
+		if !ok || info.GetReason() != test.reason || info.GetDomain() != ErrorDomain {
+			t.Errorf("%s: expected an ErrorInfo of reason %s first but got %v", test.name, test.reason, details)
+			continue
+		}
+		if test.details != nil {
+			if len(details) != 2 {
+				t.Errorf("%s: expected two details but got %v", test.name, details)
+				continue
+			}
+			test.details(t, details)
+		}
+	}
+}
+
+func TestToConnectErrorUnknown(t *testing.T) {
+	err := errors.New("connection refused")
+
+	if converted := ToConnectError(err); converted != err {
+		t.Errorf("expected an unknown error to be returned unchanged but got %v", converted)
+	}
+	if ToConnectError(nil) != nil {
+		t.Error("expected no error for nil")
+	}
+	if status := HTTPStatus(err); status != http.StatusInternalServerError {
+		t.Errorf("expected the status %d but got %d", http.StatusInternalServerError, status)
+	}
+}
+
+func TestConnectCode(t *testing.T) {
+	tests := []struct {
+		status int
+		code   connect.Code
+	}{
+		{http.StatusBadRequest, connect.CodeInvalidArgument},
+		{http.StatusUnauthorized, connect.CodeUnauthenticated},
+		{http.StatusForbidden, connect.CodePermissionDenied},
+		{http.StatusNotFound, connect.CodeNotFound},
+		{http.StatusMethodNotAllowed, connect.CodeUnimplemented},
+		{http.StatusConflict, connect.CodeFailedPrecondition},
+		{http.StatusRequestEntityTooLarge, connect.CodeResourceExhausted},
+		{http.StatusTooManyRequests, connect.CodeResourceExhausted},
+		{http.StatusServiceUnavailable, connect.CodeUnavailable},
+		{http.StatusGatewayTimeout, connect.CodeDeadlineExceeded},
+		{http.StatusInternalServerError, connect.CodeInternal},
+		{http.StatusBadGateway, connect.CodeInternal},
+		{http.StatusTeapot, connect.CodeUnknown},
+	}
+
+	for _, test := range tests {
+		if code := ConnectCode(test.status); code != test.code {
+			t.Errorf("%d: expected %s but got %s", test.status, test.code, code)
+		}
+	}
+}
+
+func expectPreconditionFailure(reason, serial string) func(t *testing.T, details []proto.Message) {
+	return func(t *testing.T, details []proto.Message) {
+		failure, ok := details[1].(*errdetails.PreconditionFailure)
+		if !ok || len(failure.GetViolations()) != 1 {
+			t.Errorf("expected a PreconditionFailure but got %v", details[1])
+			return
+		}
+		if violation := failure.GetViolations()[0]; violation.GetType() != reason || violation.GetSubject() != serial {
+			t.Errorf("expected a violation of type %s for %s but got %v", reason, serial, violation)
+		}
+	}
+}
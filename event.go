@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventTypeMatchMakerCreated  EventType = "matchmaker.created"
+	EventTypeMatchMakerStarted  EventType = "matchmaker.started"
+	EventTypeMatchMakerStopped  EventType = "matchmaker.stopped"
+	EventTypePairFinished       EventType = "pair.finished"
+	EventTypePeopleRegistered   EventType = "people.registered"
+	EventTypePeopleUnregistered EventType = "people.unregistered"
+	EventTypePeopleRestored     EventType = "people.restored"
+)
+
+// EventTypes are every type of event the service publishes.
+var EventTypes = []EventType{
+	EventTypeMatchMakerCreated,
+	EventTypeMatchMakerStarted,
+	EventTypeMatchMakerStopped,
+	EventTypePairFinished,
+	EventTypePeopleRegistered,
+	EventTypePeopleUnregistered,
+	EventTypePeopleRestored,
+}
+
+// Known reports whether the service publishes events of the type.
+func (t EventType) Known() bool {
+	for _, eventType := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type EventPayload struct {
+	MatchMakerSerial string           `json:"matchmaker_serial"`
+	Status           MatchMakerStatus `json:"status,omitempty"`
+	PairSerial       string           `json:"pair_serial,omitempty"`
+	People           []string         `json:"people,omitempty"`
+}
+
+type EventEntity struct {
+	ID               uint64
+	Serial           string
+	MatchMakerSerial string
+	Type             EventType
+	Payload          json.RawMessage
+	Attempts         int
+	NextAttemptAt    *time.Time
+	CreatedAt        time.Time
+}
+
+func NewEventEntity(eventType EventType, payload EventPayload) (*EventEntity, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventEntity{
+		Serial:           GenerateSerial(),
+		MatchMakerSerial: payload.MatchMakerSerial,
+		Type:             eventType,
+		Payload:          data,
+		CreatedAt:        time.Now(),
+	}, nil
+}
+
+// EventEnvelope is the document delivered to the event consumers.
+type EventEnvelope struct {
+	ID        string          `json:"id"`
+	Type      EventType       `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (e *EventEntity) Envelope() ([]byte, error) {
+	return json.Marshal(EventEnvelope{
+		ID:        e.Serial,
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt,
+		Data:      e.Payload,
+	})
+}
+
+type EventEntities []*EventEntity
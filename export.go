@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatCSV  ExportFormat = "csv"
+	ExportFormatJSON ExportFormat = "json"
+)
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportFormatJSON:
+		return "application/json"
+	default:
+		return "text/csv"
+	}
+}
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch strings.ToLower(format) {
+	case "", string(ExportFormatCSV):
+		return ExportFormatCSV, nil
+	case string(ExportFormatJSON):
+		return ExportFormatJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+var exportCSVHeader = []string{
+	"matchmaker_serial",
+	"matchmaker_name",
+	"matchmaker_status",
+	"pair_serial",
+	"user_reference",
+	"status",
+}
+
+type MatchMakerExport struct {
+	Serial      string                 `json:"serial"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Status      MatchMakerStatus       `json:"status"`
+	StartTime   time.Time              `json:"start_time"`
+	EndTime     time.Time              `json:"end_time"`
+	People      []MatchMakerUserExport `json:"people"`
+	Pairs       []PairExport           `json:"pairs"`
+}
+
+type MatchMakerUserExport struct {
+	Reference  string               `json:"reference"`
+	PairSerial string               `json:"pair_serial"`
+	Status     MatchMakerUserStatus `json:"status"`
+}
+
+type PairExport struct {
+	Serial     string   `json:"serial"`
+	References []string `json:"references"`
+}
+
+func NewMatchMakerExport(info *MatchMakerInformation) *MatchMakerExport {
+	export := &MatchMakerExport{
+		Serial:      info.MatchMaker.Serial,
+		Name:        info.MatchMaker.Name,
+		Description: info.MatchMaker.Description,
+		Status:      info.MatchMaker.Status,
+		StartTime:   info.MatchMaker.StartTime,
+		EndTime:     info.MatchMaker.StartTime.Add(info.MatchMaker.Duration),
+		People:      make([]MatchMakerUserExport, 0, len(info.Users)),
+		Pairs:       make([]PairExport, 0, len(info.Pairs)),
+	}
+
+	for _, user := range info.Users {
+		if user == nil {
+			continue
+		}
+		export.People = append(export.People, MatchMakerUserExport{
+			Reference:  user.UserReference,
+			PairSerial: user.Serial,
+			Status:     user.Status,
+		})
+	}
+
+	for serial, people := range info.Pairs {
+		// Pending people have not been paired yet, they share the empty serial.
+		if serial == "" {
+			continue
+		}
+		export.Pairs = append(export.Pairs, PairExport{
+			Serial:     serial.String(),
+			References: people.ToUserReferences(),
+		})
+	}
+
+	sort.Slice(export.Pairs, func(i, j int) bool {
+		return export.Pairs[i].Serial < export.Pairs[j].Serial
+	})
+
+	return export
+}
+
+func WriteMatchMakerExport(w io.Writer, info *MatchMakerInformation, format ExportFormat) error {
+	export := NewMatchMakerExport(info)
+
+	switch format {
+	case ExportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case ExportFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportCSVHeader); err != nil {
+			return err
+		}
+
+		for _, person := range export.People {
+			err := writer.Write([]string{
+				export.Serial,
+				export.Name,
+				string(export.Status),
+				person.PairSerial,
+				person.Reference,
+				string(person.Status),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ReadMatchMakerImport reads people from a CSV file with a header row.
+// The reference column is named either user_reference or reference, other columns are ignored.
+func ReadMatchMakerImport(r io.Reader) (MatchMakerUserEntities, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("import file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	referenceIdx := -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case UserReferenceColumn, "reference":
+			referenceIdx = i
+		}
+	}
+
+	if referenceIdx < 0 {
+		return nil, fmt.Errorf("import file has no %s column", UserReferenceColumn)
+	}
+
+	people := make(MatchMakerUserEntities, 0)
+	seen := make(map[string]struct{})
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if referenceIdx >= len(record) {
+			continue
+		}
+
+		reference := strings.TrimSpace(record[referenceIdx])
+		if reference == "" {
+			continue
+		}
+
+		if _, ok := seen[reference]; ok {
+			continue
+		}
+		seen[reference] = struct{}{}
+
+		person := &MatchMakerUserEntity{}
+		people = append(people, person.Build(
+			WithMatchMakerUserEntityUserReference(reference),
+			WithMatchMakerUserEntityStatus(MatchMakerUserStatusPending),
+		))
+	}
+
+	return people, nil
+}
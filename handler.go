@@ -9,12 +9,20 @@ import (
 )
 
 type Handler struct {
-	svc DonutCall
+	svc           DonutCall
+	webhooks      WebhookCall
+	notifications NotificationCall
+	audit         AuditCall
+	cfg           *Config
 }
 
-func NewHandler(donutCall DonutCall) *Handler {
+func NewHandler(donutCall DonutCall, webhookCall WebhookCall, notificationCall NotificationCall, auditCall AuditCall, cfg *Config) *Handler {
 	return &Handler{
-		svc: donutCall,
+		svc:           donutCall,
+		webhooks:      webhookCall,
+		notifications: notificationCall,
+		audit:         auditCall,
+		cfg:           cfg,
 	}
 }
 
@@ -33,7 +41,12 @@ func (h *Handler) GetMatchMakerInformation(ctx context.Context, req *connect.Req
 }
 
 func (h *Handler) StartMatchMaker(ctx context.Context, req *connect.Request[donutv1.StartMatchMakerRequest]) (*connect.Response[emptypb.Empty], error) {
-	return connect.NewResponse(&emptypb.Empty{}), h.svc.Start(ctx, req.Msg.GetSerial())
+	err := h.svc.Start(ctx, req.Msg.GetSerial())
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&emptypb.Empty{}), nil
 }
 
 func (h *Handler) StopMatchMaker(ctx context.Context, req *connect.Request[donutv1.StopMatchMakerRequest]) (*connect.Response[emptypb.Empty], error) {
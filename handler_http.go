@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+)
+
+// ExportMatchMaker serves GET /export?serial=<matchmaker serial>&format=csv|json.
+func (h *Handler) ExportMatchMaker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	serial := r.URL.Query().Get("serial")
+	if serial == "" {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("serial is empty"))
+		return
+	}
+
+	format, err := ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	info, err := h.svc.GetInformation(r.Context(), serial)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s.%s", serial, format)))
+
+	if err := WriteMatchMakerExport(w, info, format); err != nil {
+		log.Error().Err(err).Str("serial", serial).Msg("failed to write match maker export")
+	}
+}
+
+// ImportMatchMaker serves POST /import with a CSV body of people,
+// the match maker is described by the name, description, start_time (RFC 3339) and duration (days) query parameters.
+func (h *Handler) ImportMatchMaker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	matchMaker, err := parseImportMatchMakerRequest(r, h.cfg.ValidationConfig)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+		return
+	}
+
+	people, err := ReadMatchMakerImport(r.Body)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validateImportedPeople(matchMaker.Serial, people, h.cfg.ValidationConfig); err != nil {
+		writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+		return
+	}
+
+	serial, err := h.svc.ImportMatchMaker(r.Context(), matchMaker, people)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"serial": serial,
+		"people": len(people),
+	})
+}
+
+// GetPairInvitation serves GET /invitation?serial=<pair serial> as an iCalendar file.
+func (h *Handler) GetPairInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	serial := r.URL.Query().Get("serial")
+	if serial == "" {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("serial is empty"))
+		return
+	}
+
+	info, err := h.svc.GetPairInformation(r.Context(), serial)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", serial+".ics"))
+
+	invitation := NewPairInvitation(info, h.cfg.InvitationConfig)
+	if err := invitation.WriteICS(w, h.cfg.InvitationConfig); err != nil {
+		log.Error().Err(err).Str("serial", serial).Msg("failed to write pair invitation")
+	}
+}
+
+type webhookSubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type webhookSubscriptionResponse struct {
+	Serial string   `json:"serial"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+// Webhooks serves the webhook subscriptions, GET lists them, POST subscribes
+// and DELETE ?serial=<subscription serial> unsubscribes. Secrets are never returned.
+func (h *Handler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		subscriptions, err := h.webhooks.GetSubscriptions(r.Context())
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp := make([]webhookSubscriptionResponse, 0, len(subscriptions))
+		for _, subscription := range subscriptions {
+			events := make([]string, 0, len(subscription.Events))
+			for _, event := range subscription.Events {
+				events = append(events, string(event))
+			}
+			resp = append(resp, webhookSubscriptionResponse{
+				Serial: subscription.Serial,
+				URL:    subscription.URL,
+				Events: events,
+				Active: subscription.Active,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		var req webhookSubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		events := make([]EventType, 0, len(req.Events))
+		for _, event := range req.Events {
+			events = append(events, EventType(event))
+		}
+
+		subscription := &WebhookSubscriptionEntity{}
+		serial, err := h.webhooks.Subscribe(r.Context(), subscription.Build(
+			WithWebhookSubscriptionEntityURL(req.URL),
+			WithWebhookSubscriptionEntitySecret(req.Secret),
+			WithWebhookSubscriptionEntityEvents(events),
+		))
+		if err != nil {
+			writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]string{
+			"serial": serial,
+		})
+	case http.MethodDelete:
+		if err := h.webhooks.Unsubscribe(r.Context(), r.URL.Query().Get("serial")); err != nil {
+			writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+type notificationChannelRequest struct {
+	Provider         string            `json:"provider"`
+	URL              string            `json:"url"`
+	MatchMakerSerial string            `json:"matchmaker_serial"`
+	Templates        map[string]string `json:"templates"`
+}
+
+type notificationChannelResponse struct {
+	Serial           string            `json:"serial"`
+	Provider         string            `json:"provider"`
+	MatchMakerSerial string            `json:"matchmaker_serial,omitempty"`
+	Templates        map[string]string `json:"templates,omitempty"`
+	Active           bool              `json:"active"`
+}
+
+// NotificationChannels serves the chat channels notified of the match makers, GET lists them, POST creates one
+// and DELETE ?serial=<channel serial> deletes it. The webhook URLs hold their credentials and are never returned.
+func (h *Handler) NotificationChannels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		channels, err := h.notifications.GetChannels(r.Context())
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp := make([]notificationChannelResponse, 0, len(channels))
+		for _, channel := range channels {
+			templates := make(map[string]string, len(channel.Templates))
+			for kind, text := range channel.Templates {
+				templates[string(kind)] = text
+			}
+			resp = append(resp, notificationChannelResponse{
+				Serial:           channel.Serial,
+				Provider:         channel.Provider,
+				MatchMakerSerial: channel.MatchMakerSerial,
+				Templates:        templates,
+				Active:           channel.Active,
+			})
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	case http.MethodPost:
+		var req notificationChannelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		templates := make(map[NotificationKind]string, len(req.Templates))
+		for kind, text := range req.Templates {
+			templates[NotificationKind(kind)] = text
+		}
+
+		channel := &NotificationChannelEntity{}
+		serial, err := h.notifications.CreateChannel(r.Context(), channel.Build(
+			WithNotificationChannelEntityProvider(req.Provider),
+			WithNotificationChannelEntityURL(req.URL),
+			WithNotificationChannelEntityMatchMakerSerial(req.MatchMakerSerial),
+			WithNotificationChannelEntityTemplates(templates),
+		))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]string{
+			"serial": serial,
+		})
+	case http.MethodDelete:
+		if err := h.notifications.DeleteChannel(r.Context(), r.URL.Query().Get("serial")); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+type notificationDeliveryResponse struct {
+	ChannelSerial    string     `json:"channel_serial"`
+	MatchMakerSerial string     `json:"matchmaker_serial"`
+	Kind             string     `json:"kind"`
+	Status           string     `json:"status"`
+	Attempts         int        `json:"attempts"`
+	Error            string     `json:"error,omitempty"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// GetNotificationDeliveries serves GET /notifications/deliveries?channel=&matchmaker_serial=&status=&limit=,
+// newest deliveries first.
+func (h *Handler) GetNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := NotificationDeliveryFilter{
+		ChannelSerial:    query.Get("channel"),
+		MatchMakerSerial: query.Get("matchmaker_serial"),
+		Status:           NotificationStatus(query.Get("status")),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	deliveries, err := h.notifications.GetDeliveries(r.Context(), filter)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := make([]notificationDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, notificationDeliveryResponse{
+			ChannelSerial:    delivery.ChannelSerial,
+			MatchMakerSerial: delivery.MatchMakerSerial,
+			Kind:             string(delivery.Kind),
+			Status:           string(delivery.Status),
+			Attempts:         delivery.Attempts,
+			Error:            delivery.Error,
+			DeliveredAt:      delivery.DeliveredAt,
+			UpdatedAt:        delivery.UpdatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type restorePeopleRequest struct {
+	MatchMakerSerial string   `json:"matchmaker_serial"`
+	References       []string `json:"references"`
+}
+
+// RestorePeople serves POST /people/restore with a JSON body of the match maker serial and the references to restore.
+func (h *Handler) RestorePeople(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	var req restorePeopleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.MatchMakerSerial == "" || len(req.References) == 0 {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("matchmaker_serial and references are required"))
+		return
+	}
+
+	people := make(MatchMakerUserEntities, 0, len(req.References))
+	for _, reference := range req.References {
+		entity := &MatchMakerUserEntity{}
+		people = append(people, entity.Build(
+			WithMatchMakerUserEntityMatchMakerSerial(req.MatchMakerSerial),
+			WithMatchMakerUserEntityUserReference(reference),
+		))
+	}
+
+	restored, err := h.svc.RestorePeople(r.Context(), people)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int64{
+		"restored": restored,
+	})
+}
+
+type erasePersonRequest struct {
+	Reference string `json:"reference"`
+}
+
+type erasePersonResponse struct {
+	Pseudonym string `json:"pseudonym"`
+	Erased    int64  `json:"erased"`
+}
+
+// ErasePerson serves POST /people/erase with a JSON body of the reference to erase from every match maker.
+func (h *Handler) ErasePerson(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	var req erasePersonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pseudonym, erased, err := h.svc.ErasePerson(r.Context(), req.Reference)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, erasePersonResponse{
+		Pseudonym: pseudonym,
+		Erased:    erased,
+	})
+}
+
+type auditLogResponse struct {
+	Serial       string          `json:"serial"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	TargetSerial string          `json:"target_serial"`
+	Before       json.RawMessage `json:"before"`
+	After        json.RawMessage `json:"after"`
+	RequestID    string          `json:"request_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+type auditLogPageResponse struct {
+	Entries       []auditLogResponse `json:"entries"`
+	NextPageToken string             `json:"next_page_token,omitempty"`
+}
+
+// GetAuditLogs serves GET /audit?actor=&action=&target=&page_size=&page_token=, newest entries first.
+func (h *Handler) GetAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AuditLogFilter{
+		Actor:        query.Get("actor"),
+		Action:       AuditAction(query.Get("action")),
+		TargetSerial: query.Get("target"),
+		PageToken:    query.Get("page_token"),
+	}
+
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		parsed, err := strconv.Atoi(pageSize)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid page_size: %w", err))
+			return
+		}
+		filter.PageSize = parsed
+	}
+
+	page, err := h.audit.GetAuditLogs(r.Context(), filter)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := auditLogPageResponse{
+		Entries:       make([]auditLogResponse, 0, len(page.Entries)),
+		NextPageToken: page.NextPageToken,
+	}
+	for _, entry := range page.Entries {
+		resp.Entries = append(resp.Entries, auditLogResponse{
+			Serial:       entry.Serial,
+			Actor:        entry.Actor,
+			Action:       string(entry.Action),
+			TargetSerial: entry.TargetSerial,
+			Before:       entry.Before,
+			After:        entry.After,
+			RequestID:    entry.RequestID,
+			CreatedAt:    entry.CreatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseImportMatchMakerRequest converts the query to the request of CreateMatchMaker, so the match maker is validated
+// by the same rules, see NewCreateMatchMakerRequest.
+func parseImportMatchMakerRequest(r *http.Request, cfg ValidationConfig) (*MatchMakerEntity, error) {
+	query := r.URL.Query()
+
+	var startTime *time.Time
+	if value := query.Get("start_time"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, NewValidationError("start_time", "is not an RFC 3339 time")
+		}
+		startTime = &parsed
+	}
+
+	days := int64(1)
+	if value := query.Get("duration"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, NewValidationError("duration", "is not a number of days")
+		}
+		days = parsed
+	}
+
+	msg := NewCreateMatchMakerRequest(query.Get("name"), query.Get("description"), startTime, int32(days))
+	if err := ValidateRequest(msg, cfg); err != nil {
+		return nil, err
+	}
+
+	return parseCreateMatchMakerRequest(connect.NewRequest(msg)), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"gorm.io/gorm"
+)
+
+const (
+	HealthServiceName      = "grpc.health.v1.Health"
+	healthCheckProcedure   = "/grpc.health.v1.Health/Check"
+	healthWatchProcedure   = "/grpc.health.v1.Health/Watch"
+	healthStatusServing    = "serving"
+	healthStatusNotServing = "not_serving"
+)
+
+type HealthConfig struct {
+	CheckTimeout  time.Duration `env:"HEALTH_CHECK_TIMEOUT" envDefault:"2s"`
+	WatchInterval time.Duration `env:"HEALTH_WATCH_INTERVAL" envDefault:"5s"`
+	// DrainDelay is how long the server keeps serving after it reports not serving, for the load balancers
+	// to notice before the connections are closed
+	DrainDelay time.Duration `env:"HEALTH_DRAIN_DELAY" envDefault:"5s"`
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+func (r HealthReport) Serving() bool {
+	return r.Status == healthStatusServing
+}
+
+// HealthChecker reports whether the service can take traffic: the database answers, its schema is migrated
+// and the server is not shutting down.
+type HealthChecker struct {
+	db       *gorm.DB
+	services map[string]struct{}
+	cfg      HealthConfig
+
+	shuttingDown atomic.Bool
+}
+
+func NewHealthChecker(db *gorm.DB, cfg HealthConfig, services ...string) *HealthChecker {
+	known := make(map[string]struct{}, len(services))
+	for _, service := range services {
+		known[service] = struct{}{}
+	}
+
+	return &HealthChecker{
+		db:       db,
+		services: known,
+		cfg:      cfg,
+	}
+}
+
+// Shutdown makes every following readiness check fail, so the orchestrator stops routing traffic
+// while the server drains its connections.
+func (h *HealthChecker) Shutdown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *HealthChecker) Ready(ctx context.Context) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.CheckTimeout)
+	defer cancel()
+
+	checks := []HealthCheck{
+		newHealthCheck("shutdown", h.checkShutdown()),
+		newHealthCheck("database", h.checkDatabase(ctx)),
+		newHealthCheck("schema", h.checkSchema(ctx)),
+	}
+
+	report := HealthReport{Status: healthStatusServing, Checks: checks}
+	for _, check := range checks {
+		if check.Status != healthStatusServing {
+			report.Status = healthStatusNotServing
+		}
+	}
+
+	return report
+}
+
+func (h *HealthChecker) checkShutdown() error {
+	if h.shuttingDown.Load() {
+		return fmt.Errorf("server is shutting down")
+	}
+	return nil
+}
+
+func (h *HealthChecker) checkDatabase(ctx context.Context) error {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (h *HealthChecker) checkSchema(ctx context.Context) error {
+	version, err := CurrentSchemaVersion(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	if version < SchemaVersion {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaOutdated, version, SchemaVersion)
+	}
+
+	return nil
+}
+
+func newHealthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Status: healthStatusNotServing, Error: err.Error()}
+	}
+	return HealthCheck{Name: name, Status: healthStatusServing}
+}
+
+// Live serves GET /healthz, it only tells that the process is able to answer.
+func (h *HealthChecker) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthReport{Status: healthStatusServing})
+}
+
+// Readiness serves GET /readyz with the result of every check.
+func (h *HealthChecker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Ready(r.Context())
+
+	status := http.StatusOK
+	if !report.Serving() {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+// NewGRPCHealthHandler serves the grpc.health.v1.Health service over the gRPC, gRPC-Web and Connect protocols.
+// The empty service name reports the server as a whole, the other names are the services registered on the checker.
+func NewGRPCHealthHandler(checker *HealthChecker, options ...connect.HandlerOption) (string, http.Handler) {
+	check := connect.NewUnaryHandler(healthCheckProcedure, checker.grpcCheck, options...)
+	watch := connect.NewServerStreamHandler(healthWatchProcedure, checker.grpcWatch, options...)
+
+	mux := http.NewServeMux()
+	mux.Handle(healthCheckProcedure, check)
+	mux.Handle(healthWatchProcedure, watch)
+
+	return "/" + HealthServiceName + "/", mux
+}
+
+func (h *HealthChecker) servingStatus(ctx context.Context, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	if service != "" {
+		if _, ok := h.services[service]; !ok {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, connect.NewError(connect.CodeNotFound, fmt.Errorf("unknown service %s", service))
+		}
+	}
+
+	if !h.Ready(ctx).Serving() {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, nil
+	}
+
+	return grpc_health_v1.HealthCheckResponse_SERVING, nil
+}
+
+func (h *HealthChecker) grpcCheck(ctx context.Context, req *connect.Request[grpc_health_v1.HealthCheckRequest]) (*connect.Response[grpc_health_v1.HealthCheckResponse], error) {
+	status, err := h.servingStatus(ctx, req.Msg.GetService())
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&grpc_health_v1.HealthCheckResponse{Status: status}), nil
+}
+
+// grpcWatch sends the current status and then every change of it, an unknown service is reported
+// as SERVICE_UNKNOWN instead of failing the stream as the protocol requires.
+func (h *HealthChecker) grpcWatch(ctx context.Context, req *connect.Request[grpc_health_v1.HealthCheckRequest], stream *connect.ServerStream[grpc_health_v1.HealthCheckResponse]) error {
+	ticker := time.NewTicker(h.cfg.WatchInterval)
+	defer ticker.Stop()
+
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+
+	for {
+		status, _ := h.servingStatus(ctx, req.Msg.GetService())
+		if status != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+			last = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
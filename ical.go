@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+	icalLineLimit  = 75
+)
+
+type InvitationConfig struct {
+	Duration       time.Duration `env:"INVITATION_DURATION" envDefault:"30m"`
+	Organizer      string        `env:"INVITATION_ORGANIZER"`
+	AttendeeDomain string        `env:"INVITATION_ATTENDEE_DOMAIN" envDefault:"donut.invalid"`
+	ProductID      string        `env:"INVITATION_PRODUCT_ID" envDefault:"-//mocha-bot//donut//EN"`
+}
+
+// AttendeeAddress builds the calendar address of a person, people only carry their reference so
+// the address is the reference within the configured domain.
+func (cfg InvitationConfig) AttendeeAddress(person *Person) string {
+	return fmt.Sprintf("mailto:%s@%s", person.Name, cfg.AttendeeDomain)
+}
+
+type Invitation struct {
+	UID         string
+	Summary     string
+	Description string
+	Organizer   string
+	Attendees   People
+	StartTime   time.Time
+	EndTime     time.Time
+	CreatedAt   time.Time
+}
+
+// NewPairInvitation builds the meeting of a pair, it starts with the match maker and
+// lasts the configured duration without going past the end of the match maker.
+func NewPairInvitation(info *MatchMakerInformation, cfg InvitationConfig) *Invitation {
+	pairSerial, people := info.Pairs.First()
+
+	startTime := info.MatchMaker.StartTime
+	endTime := startTime.Add(cfg.Duration)
+	if matchMakerEndTime := startTime.Add(info.MatchMaker.Duration); info.MatchMaker.Duration > 0 && endTime.After(matchMakerEndTime) {
+		endTime = matchMakerEndTime
+	}
+
+	return &Invitation{
+		UID:         fmt.Sprintf("%s@donut", pairSerial),
+		Summary:     fmt.Sprintf("%s: %s", info.MatchMaker.Name, people.Print()),
+		Description: info.MatchMaker.Description,
+		Organizer:   cfg.Organizer,
+		Attendees:   people,
+		StartTime:   startTime,
+		EndTime:     endTime,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// WriteICS writes the invitation as an RFC 5545 calendar with a single event.
+func (i *Invitation) WriteICS(w io.Writer, cfg InvitationConfig) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + cfg.ProductID,
+		"CALSCALE:GREGORIAN",
+		"METHOD:REQUEST",
+		"BEGIN:VEVENT",
+		"UID:" + i.UID,
+		"DTSTAMP:" + i.CreatedAt.UTC().Format(icalTimeFormat),
+		"DTSTART:" + i.StartTime.UTC().Format(icalTimeFormat),
+		"DTEND:" + i.EndTime.UTC().Format(icalTimeFormat),
+		"SUMMARY:" + escapeICSText(i.Summary),
+	}
+
+	if i.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeICSText(i.Description))
+	}
+
+	if i.Organizer != "" {
+		lines = append(lines, "ORGANIZER:mailto:"+i.Organizer)
+	}
+
+	for _, person := range i.Attendees {
+		if person == nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(
+			"ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:%s",
+			quoteICSParam(person.Name),
+			cfg.AttendeeAddress(person),
+		))
+	}
+
+	lines = append(lines,
+		"STATUS:CONFIRMED",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldICSLine(line)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func escapeICSText(text string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return replacer.Replace(text)
+}
+
+func quoteICSParam(param string) string {
+	return `"` + strings.ReplaceAll(param, `"`, "'") + `"`
+}
+
+// foldICSLine splits lines longer than 75 octets into continuation lines and terminates them with CRLF.
+func foldICSLine(line string) string {
+	var builder strings.Builder
+	limit := icalLineLimit
+
+	for len(line) > limit {
+		cut := limit
+		// Never split a multi-byte character.
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		builder.WriteString(line[:cut])
+		builder.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space which counts toward the limit.
+		limit = icalLineLimit - 1
+	}
+
+	builder.WriteString(line)
+	builder.WriteString("\r\n")
+	return builder.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeICSText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Coffee", "Coffee"},
+		{"Ann, Bob; Carl", `Ann\, Bob\; Carl`},
+		{`C:\donut`, `C:\\donut`},
+		{"first\nsecond\r\nthird", `first\nsecond\nthird`},
+		{`\,;`, `\\\,\;`},
+	}
+
+	for _, test := range tests {
+		if escaped := escapeICSText(test.text); escaped != test.expected {
+			t.Errorf("escapeICSText(%q): expected %q but got %q", test.text, test.expected, escaped)
+		}
+	}
+}
+
+func TestFoldICSLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected string
+	}{
+		{
+			name:     "short",
+			line:     "SUMMARY:Coffee",
+			expected: "SUMMARY:Coffee\r\n",
+		},
+		{
+			name:     "exactly the limit",
+			line:     strings.Repeat("a", icalLineLimit),
+			expected: strings.Repeat("a", icalLineLimit) + "\r\n",
+		},
+		{
+			name: "ascii",
+			line: strings.Repeat("a", 200),
+			expected: strings.Repeat("a", 75) + "\r\n " +
+				strings.Repeat("a", 74) + "\r\n " +
+				strings.Repeat("a", 51) + "\r\n",
+		},
+		{
+			// é takes two octets, the 75th octet is the middle of one so the first line ends at 74
+			name: "multibyte",
+			line: "DESCRIPTION:" + strings.Repeat("é", 40),
+			expected: "DESCRIPTION:" + strings.Repeat("é", 31) + "\r\n " +
+				strings.Repeat("é", 9) + "\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		folded := foldICSLine(test.line)
+		if folded != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.name, test.expected, folded)
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+			if len(line) > icalLineLimit {
+				t.Errorf("%s: line of %d octets is longer than %d", test.name, len(line), icalLineLimit)
+			}
+		}
+	}
+}
+
+func TestWriteICS(t *testing.T) {
+	startTime := time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC)
+	invitation := &Invitation{
+		UID:         "pair-serial@donut",
+		Summary:     "Coffee: Ann, Bob",
+		Description: "Bring; snacks\nand a \\ backslash",
+		Organizer:   "host@example.com",
+		Attendees:   People{{Name: "ann"}, nil, {Name: `b"ob`}},
+		StartTime:   startTime,
+		EndTime:     startTime.Add(30 * time.Minute),
+		CreatedAt:   time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("WIB", 7*60*60)),
+	}
+	cfg := InvitationConfig{
+		AttendeeDomain: "example.com",
+		ProductID:      "-//mocha-bot//donut//EN",
+	}
+
+	var builder strings.Builder
+	if err := invitation.WriteICS(&builder, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"PRODID:-//mocha-bot//donut//EN\r\n" +
+		"CALSCALE:GREGORIAN\r\n" +
+		"METHOD:REQUEST\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:pair-serial@donut\r\n" +
+		"DTSTAMP:20240301T050000Z\r\n" +
+		"DTSTART:20240304T093000Z\r\n" +
+		"DTEND:20240304T100000Z\r\n" +
+		"SUMMARY:Coffee: Ann\\, Bob\r\n" +
+		"DESCRIPTION:Bring\\; snacks\\nand a \\\\ backslash\r\n" +
+		"ORGANIZER:mailto:host@example.com\r\n" +
+		"ATTENDEE;CN=\"ann\";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mail\r\n" +
+		" to:ann@example.com\r\n" +
+		"ATTENDEE;CN=\"b'ob\";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mai\r\n" +
+		" lto:b\"ob@example.com\r\n" +
+		"STATUS:CONFIRMED\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	if builder.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, builder.String())
+	}
+}
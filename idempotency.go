@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"buf.build/gen/go/mocha/remcall/connectrpc/go/donut/v1/donutv1connect"
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"connectrpc.com/connect"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	CallerColumn      = "caller"
+	KeyColumn         = "key"
+	ProcedureColumn   = "procedure"
+	FingerprintColumn = "fingerprint"
+	ResponseColumn    = "response"
+	CompletedColumn   = "completed"
+	ExpiresAtColumn   = "expires_at"
+
+	maxIdempotencyKeyLength = 255
+)
+
+type IdempotencyConfig struct {
+	// TTL keeps the response of a completed request, Lease keeps a request in progress, a request whose server
+	// stopped before completing it can run again once its lease is over
+	TTL           time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	Lease         time.Duration `env:"IDEMPOTENCY_LEASE" envDefault:"1m"`
+	PurgeInterval time.Duration `env:"IDEMPOTENCY_PURGE_INTERVAL" envDefault:"1h"`
+}
+
+// IdempotencyKeyEntity is a key sent by a caller for a procedure, the keys of different callers never collide.
+// It expires at the end of its lease while the request is in progress, and at the end of the TTL once completed.
+type IdempotencyKeyEntity struct {
+	Caller      string
+	Key         string
+	Procedure   string
+	Fingerprint string
+	Response    []byte
+	Completed   bool
+	ExpiresAt   time.Time
+}
+
+func (i *IdempotencyKeyEntity) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+type IdempotencyKey struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	Caller      string `gorm:"uniqueIndex:idx_idempotency_caller_key_procedure;size:255"`
+	Key         string `gorm:"uniqueIndex:idx_idempotency_caller_key_procedure;size:255"`
+	Procedure   string `gorm:"uniqueIndex:idx_idempotency_caller_key_procedure;size:255"`
+	Fingerprint string `gorm:"size:64"`
+	Response    []byte
+	Completed   bool
+	ExpiresAt   time.Time `gorm:"index"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+func (IdempotencyKey) TableName() string {
+	return "idempotency_key"
+}
+
+func (IdempotencyKey) FromEntity(entity *IdempotencyKeyEntity) *IdempotencyKey {
+	if entity == nil {
+		return nil
+	}
+
+	return &IdempotencyKey{
+		Caller:      entity.Caller,
+		Key:         entity.Key,
+		Procedure:   entity.Procedure,
+		Fingerprint: entity.Fingerprint,
+		Response:    entity.Response,
+		Completed:   entity.Completed,
+		ExpiresAt:   entity.ExpiresAt,
+	}
+}
+
+func (i *IdempotencyKey) ToEntity() *IdempotencyKeyEntity {
+	if i == nil {
+		return nil
+	}
+
+	return &IdempotencyKeyEntity{
+		Caller:      i.Caller,
+		Key:         i.Key,
+		Procedure:   i.Procedure,
+		Fingerprint: i.Fingerprint,
+		Response:    i.Response,
+		Completed:   i.Completed,
+		ExpiresAt:   i.ExpiresAt,
+	}
+}
+
+type idempotencyRepository struct {
+	db *gorm.DB
+}
+
+type IdempotencyRepository interface {
+	ClaimIdempotencyKey(ctx context.Context, key *IdempotencyKeyEntity) (bool, error)
+	TakeOverIdempotencyKey(ctx context.Context, key *IdempotencyKeyEntity, now time.Time) (bool, error)
+	GetIdempotencyKey(ctx context.Context, caller, key, procedure string) (*IdempotencyKeyEntity, error)
+	CompleteIdempotencyKey(ctx context.Context, caller, key, procedure string, response []byte, expiresAt time.Time) error
+	DeleteIdempotencyKey(ctx context.Context, caller, key, procedure string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository {
+	return &idempotencyRepository{
+		db: db,
+	}
+}
+
+// ClaimIdempotencyKey stores the key unless it is already stored and reports whether this call stored it.
+func (r *idempotencyRepository) ClaimIdempotencyKey(ctx context.Context, key *IdempotencyKeyEntity) (bool, error) {
+	result := transactionOrDB(ctx, r.db).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(IdempotencyKey{}.FromEntity(key))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// TakeOverIdempotencyKey claims a key whose stored request expired, unless another request took it over first,
+// and reports whether this call took it over.
+func (r *idempotencyRepository) TakeOverIdempotencyKey(ctx context.Context, key *IdempotencyKeyEntity, now time.Time) (bool, error) {
+	q := fmt.Sprintf("%s AND %s <= ?", r.where(), ExpiresAtColumn)
+	result := transactionOrDB(ctx, r.db).
+		Model(&IdempotencyKey{}).
+		Where(q, key.Caller, key.Key, key.Procedure, now).
+		Updates(map[string]interface{}{
+			FingerprintColumn: key.Fingerprint,
+			ResponseColumn:    nil,
+			CompletedColumn:   false,
+			ExpiresAtColumn:   key.ExpiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *idempotencyRepository) GetIdempotencyKey(ctx context.Context, caller, key, procedure string) (*IdempotencyKeyEntity, error) {
+	var idempotencyKey IdempotencyKey
+	err := transactionOrDB(ctx, r.db).Where(r.where(), caller, key, procedure).First(&idempotencyKey).Error
+	if err != nil {
+		return nil, err
+	}
+	return idempotencyKey.ToEntity(), nil
+}
+
+// CompleteIdempotencyKey stores the response of the request and keeps it until expiresAt.
+func (r *idempotencyRepository) CompleteIdempotencyKey(ctx context.Context, caller, key, procedure string, response []byte, expiresAt time.Time) error {
+	return transactionOrDB(ctx, r.db).
+		Model(&IdempotencyKey{}).
+		Where(r.where(), caller, key, procedure).
+		Updates(map[string]interface{}{
+			ResponseColumn:  response,
+			CompletedColumn: true,
+			ExpiresAtColumn: expiresAt,
+		}).
+		Error
+}
+
+func (r *idempotencyRepository) DeleteIdempotencyKey(ctx context.Context, caller, key, procedure string) error {
+	return transactionOrDB(ctx, r.db).Where(r.where(), caller, key, procedure).Delete(&IdempotencyKey{}).Error
+}
+
+func (r *idempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	q := fmt.Sprintf("%s <= ?", ExpiresAtColumn)
+	result := transactionOrDB(ctx, r.db).Where(q, now).Delete(&IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
+
+// where is the condition of a key of a caller for a procedure. The columns are quoted as key is a reserved
+// word in MySQL.
+func (r *idempotencyRepository) where() string {
+	return fmt.Sprintf("%s = ? AND %s = ? AND %s = ?",
+		r.db.Statement.Quote(CallerColumn), r.db.Statement.Quote(KeyColumn), r.db.Statement.Quote(ProcedureColumn))
+}
+
+// idempotentProcedures maps every mutating unary procedure to the decoder of its stored response.
+// Connect requires the replayed response to have the concrete type of the procedure.
+var idempotentProcedures = map[string]func([]byte) (connect.AnyResponse, error){
+	donutv1connect.MatchMakerServiceCreateMatchMakerProcedure: replayResponse[donutv1.CreateMatchMakerResponse],
+	donutv1connect.MatchMakerServiceStartMatchMakerProcedure:  replayResponse[emptypb.Empty],
+	donutv1connect.MatchMakerServiceStopMatchMakerProcedure:   replayResponse[emptypb.Empty],
+	donutv1connect.PeopleServiceCallPeopleProcedure:           replayResponse[emptypb.Empty],
+}
+
+func replayResponse[T any, PT interface {
+	*T
+	proto.Message
+}](payload []byte) (connect.AnyResponse, error) {
+	message := PT(new(T))
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+
+	response := connect.NewResponse((*T)(message))
+	response.Header().Set(IdempotencyReplayedHeader, "true")
+	return response, nil
+}
+
+type idempotencyInterceptor struct {
+	repo IdempotencyRepository
+	cfg  IdempotencyConfig
+}
+
+// NewIdempotencyInterceptor makes the mutating procedures safe to retry. The first request carrying an
+// Idempotency-Key header runs and its response is stored for the TTL, a retry of the same caller with the same key
+// and request gets the stored response back without running the procedure again.
+func NewIdempotencyInterceptor(repo IdempotencyRepository, cfg IdempotencyConfig) connect.Interceptor {
+	return &idempotencyInterceptor{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
+
+func (i *idempotencyInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		key := req.Header().Get(IdempotencyKeyHeader)
+		replay, ok := idempotentProcedures[req.Spec().Procedure]
+		if key == "" || !ok || req.Spec().IsClient {
+			return next(ctx, req)
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength))
+		}
+
+		fingerprint, err := fingerprintRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		entity := &IdempotencyKeyEntity{
+			Caller:      requestCaller(ctx, req.Peer()),
+			Key:         key,
+			Procedure:   req.Spec().Procedure,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(i.cfg.Lease),
+		}
+
+		stored, err := i.claim(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+
+		if stored != nil {
+			if stored.Fingerprint != fingerprint {
+				return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("idempotency key was used for a different request"))
+			}
+			if !stored.Completed {
+				return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("request with the same idempotency key is in progress"))
+			}
+			return replay(stored.Response)
+		}
+
+		resp, err := next(ctx, req)
+		if err != nil {
+			// Release the key so that the retry runs the procedure again
+			i.release(context.WithoutCancel(ctx), entity)
+			return nil, err
+		}
+
+		payload, err := proto.Marshal(resp.Any().(proto.Message))
+		i.complete(context.WithoutCancel(ctx), entity, payload, err)
+
+		return resp, nil
+	}
+}
+
+// claim stores the key for this request, or returns the key stored by an earlier request.
+// An expired key, the response of a completed request past the TTL or a request in progress past its lease,
+// is taken over as if it was never stored.
+func (i *idempotencyInterceptor) claim(ctx context.Context, entity *IdempotencyKeyEntity) (*IdempotencyKeyEntity, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := i.repo.ClaimIdempotencyKey(ctx, entity)
+		if err != nil || claimed {
+			return nil, err
+		}
+
+		stored, err := i.repo.GetIdempotencyKey(ctx, entity.Caller, entity.Key, entity.Procedure)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		if !stored.Expired(now) {
+			return stored, nil
+		}
+
+		taken, err := i.repo.TakeOverIdempotencyKey(ctx, entity, now)
+		if err != nil || taken {
+			return nil, err
+		}
+	}
+
+	return nil, connect.NewError(connect.CodeAborted, fmt.Errorf("request with the same idempotency key is in progress"))
+}
+
+// complete stores the response of the request for the TTL. The key is released when the response can't be
+// stored, a retry runs the request again rather than being refused until the lease is over.
+func (i *idempotencyInterceptor) complete(ctx context.Context, entity *IdempotencyKeyEntity, payload []byte, err error) {
+	if err == nil {
+		err = i.repo.CompleteIdempotencyKey(ctx, entity.Caller, entity.Key, entity.Procedure, payload, time.Now().Add(i.cfg.TTL))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("key", entity.Key).Msg("failed to store idempotent response")
+		i.release(ctx, entity)
+	}
+}
+
+func (i *idempotencyInterceptor) release(ctx context.Context, entity *IdempotencyKeyEntity) {
+	if err := i.repo.DeleteIdempotencyKey(ctx, entity.Caller, entity.Key, entity.Procedure); err != nil {
+		log.Error().Err(err).Str("key", entity.Key).Msg("failed to release idempotency key")
+	}
+}
+
+func (i *idempotencyInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *idempotencyInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// restIdempotentResponse is the response of a REST route stored under its idempotency key.
+type restIdempotentResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// restReplayedHeaders are the headers of a response kept to be replayed.
+var restReplayedHeaders = []string{"Content-Type", "Location"}
+
+// NewRESTIdempotencyMiddleware makes the mutating routes of the REST API safe to retry, as the idempotency
+// interceptor does for the procedures. The request is told apart by its method, path and body, and the status,
+// headers and body of a successful response are stored to be replayed.
+func NewRESTIdempotencyMiddleware(repo IdempotencyRepository, cfg IdempotencyConfig) RESTMiddleware {
+	interceptor := &idempotencyInterceptor{
+		repo: repo,
+		cfg:  cfg,
+	}
+
+	return func(procedure string, next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodDelete) {
+				next(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			entity := &IdempotencyKeyEntity{
+				Caller:      requestCaller(r.Context(), connect.Peer{Addr: r.RemoteAddr}),
+				Key:         key,
+				Procedure:   procedure,
+				Fingerprint: fingerprintRESTRequest(r, body),
+				ExpiresAt:   time.Now().Add(cfg.Lease),
+			}
+
+			stored, err := interceptor.claim(r.Context(), entity)
+			if err != nil {
+				writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+				return
+			}
+
+			if stored != nil {
+				if stored.Fingerprint != entity.Fingerprint {
+					writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("idempotency key was used for a different request"))
+					return
+				}
+				if !stored.Completed {
+					writeHTTPError(w, http.StatusConflict, fmt.Errorf("request with the same idempotency key is in progress"))
+					return
+				}
+				replayRESTResponse(w, stored.Response)
+				return
+			}
+
+			recorder := &restResponseRecorder{ResponseWriter: w, body: &bytes.Buffer{}}
+			next(recorder, r)
+
+			ctx := context.WithoutCancel(r.Context())
+			if recorder.err() != nil {
+				// Release the key so that the retry runs the route again
+				interceptor.release(ctx, entity)
+				return
+			}
+
+			response := restIdempotentResponse{
+				Status: recorder.statusCode(),
+				Header: make(http.Header),
+				Body:   recorder.body.Bytes(),
+			}
+			for _, name := range restReplayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					response.Header.Set(name, value)
+				}
+			}
+
+			payload, err := json.Marshal(response)
+			interceptor.complete(ctx, entity, payload, err)
+		}
+	}
+}
+
+func replayRESTResponse(w http.ResponseWriter, payload []byte) {
+	var response restIdempotentResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, fmt.Errorf("failed to replay idempotent response: %w", err))
+		return
+	}
+
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotencyReplayedHeader, "true")
+	w.WriteHeader(response.Status)
+	if _, err := w.Write(response.Body); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+// fingerprintRESTRequest hashes the method, the path, which holds the parameters, and the body of the request.
+func fingerprintRESTRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.EscapedPath() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func fingerprintRequest(req connect.AnyRequest) (string, error) {
+	message, ok := req.Any().(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("request of %s is not a protobuf message", req.Spec().Procedure)
+	}
+
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RunIdempotencyPurge deletes the expired keys every interval until the context is done.
+func RunIdempotencyPurge(ctx context.Context, repo IdempotencyRepository, cfg IdempotencyConfig) {
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := repo.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to purge expired idempotency keys")
+			continue
+		}
+		log.Debug().Int64("deleted", deleted).Msg("purged expired idempotency keys")
+	}
+}
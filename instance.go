@@ -10,8 +10,42 @@ import (
 )
 
 func NewDatabaseInstance(conf *Config) (*gorm.DB, error) {
+	dialector, err := conf.DatabaseConfig.GetDialector()
+	if err != nil {
+		return nil, err
+	}
+
+	return openDatabase(conf, dialector)
+}
+
+// NewReplicaInstances opens the read replicas, they share the settings of the primary except for the DSN.
+func NewReplicaInstances(conf *Config) ([]*gorm.DB, error) {
+	replicas := make([]*gorm.DB, 0, len(conf.DatabaseConfig.ReplicaDSNs))
+
+	for _, dsn := range conf.DatabaseConfig.ReplicaDSNs {
+		dialector, err := conf.DatabaseConfig.GetDialectorByDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		replica, err := openDatabase(conf, dialector)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+
+	return replicas, nil
+}
+
+func openDatabase(conf *Config, dialector gorm.Dialector) (*gorm.DB, error) {
 	gormConf := new(gorm.Config)
 
+	// Store the times gorm fills in, such as created_at, in UTC like the match maker times
+	gormConf.NowFunc = func() time.Time {
+		return time.Now().UTC()
+	}
+
 	if conf.DatabaseConfig.LogLevel != "" {
 		gormConf.Logger = logger.New(
 			log.New(os.Stdout, "\r\n", log.LstdFlags),
@@ -23,15 +57,19 @@ func NewDatabaseInstance(conf *Config) (*gorm.DB, error) {
 		)
 	}
 
-	dialector, err := conf.DatabaseConfig.GetDialector()
+	instance, err := gorm.Open(dialector, gormConf)
 	if err != nil {
 		return nil, err
 	}
 
-	instance, err := gorm.Open(dialector, gormConf)
+	sqlDB, err := instance.DB()
 	if err != nil {
 		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(conf.DatabaseConfig.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(conf.DatabaseConfig.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(conf.DatabaseConfig.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(conf.DatabaseConfig.ConnMaxIdleTime)
 
 	if conf.DatabaseConfig.Debug {
 		return instance.Debug(), nil
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// maxRateLimitedCallers bounds the limiters kept in memory, the least recent callers are forgotten first.
+	maxRateLimitedCallers = 10000
+	rateLimiterTTL        = 10 * time.Minute
+)
+
+var (
+	ErrRateLimited     = errors.New("rate limited")
+	ErrStreamExhausted = errors.New("stream exhausted")
+)
+
+type LimitConfig struct {
+	Enabled bool `env:"LIMIT_ENABLED" envDefault:"true"`
+	// Rate is the requests and stream messages allowed per second to a caller, Burst the most allowed at once
+	Rate  float64 `env:"LIMIT_RATE" envDefault:"20"`
+	Burst int     `env:"LIMIT_BURST" envDefault:"40"`
+
+	StreamMaxMessages int           `env:"LIMIT_STREAM_MAX_MESSAGES" envDefault:"1000"`
+	StreamIdleTimeout time.Duration `env:"LIMIT_STREAM_IDLE_TIMEOUT" envDefault:"1m"`
+
+	MaxRequestBytes int `env:"LIMIT_MAX_REQUEST_BYTES" envDefault:"4194304"`
+}
+
+// rateLimiter keeps a token bucket per caller. A caller is the authenticated actor of the request, which names
+// the API key, or the address of the peer for the anonymous requests.
+type rateLimiter struct {
+	cfg LimitConfig
+
+	mu       sync.Mutex
+	limiters *expirable.LRU[string, *rate.Limiter]
+}
+
+func newRateLimiter(cfg LimitConfig) *rateLimiter {
+	return &rateLimiter{
+		cfg:      cfg,
+		limiters: expirable.NewLRU[string, *rate.Limiter](maxRateLimitedCallers, nil, rateLimiterTTL),
+	}
+}
+
+func (l *rateLimiter) limiter(caller string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters.Get(caller)
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.cfg.Rate), l.cfg.Burst)
+		l.limiters.Add(caller, limiter)
+	}
+	return limiter
+}
+
+// allow takes a token of the caller, or returns a ResourceExhausted error telling when to retry.
+func (l *rateLimiter) allow(ctx context.Context, peer connect.Peer) error {
+	_, err := l.reserve(ctx, peer)
+	return err
+}
+
+// reserve is allow returning the delay to wait before retrying too.
+func (l *rateLimiter) reserve(ctx context.Context, peer connect.Peer) (time.Duration, error) {
+	caller := requestCaller(ctx, peer)
+
+	reservation := l.limiter(caller).Reserve()
+	delay := reservation.Delay()
+	if reservation.OK() && delay == 0 {
+		return 0, nil
+	}
+	reservation.Cancel()
+
+	err := fmt.Errorf("%w: %s sent more than %v requests per second", ErrRateLimited, caller, l.cfg.Rate)
+	return delay, newConnectError(connect.CodeResourceExhausted, err, errorReasons[ErrRateLimited], map[string]string{"caller": caller}, &errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+}
+
+type limitInterceptor struct {
+	cfg     LimitConfig
+	limiter *rateLimiter
+}
+
+// NewLimitInterceptor rate limits the requests and the stream messages of every caller, and closes the streams
+// that receive too many messages or none for too long. The size of the messages is limited by the handler options.
+// The limiter is shared with the REST API, see NewRESTLimitMiddleware.
+func NewLimitInterceptor(limiter *rateLimiter) connect.Interceptor {
+	return &limitInterceptor{
+		cfg:     limiter.cfg,
+		limiter: limiter,
+	}
+}
+
+// NewRESTLimitMiddleware rate limits the requests of the REST API with the limiter of the procedures, so a caller
+// has the same budget on both, see limitRate.
+func NewRESTLimitMiddleware(limiter *rateLimiter) RESTMiddleware {
+	return func(_ string, next http.HandlerFunc) http.HandlerFunc {
+		return limitRate(limiter, next)
+	}
+}
+
+// limitRate takes a token of the caller for every request of a plain HTTP endpoint, a limited request is
+// answered 429 Too Many Requests with a Retry-After header.
+func limitRate(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if !limiter.cfg.Enabled {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		delay, err := limiter.reserve(r.Context(), connect.Peer{Addr: r.RemoteAddr})
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// LimitHandlerOptions returns the options of the connect handlers that limit the size of the messages.
+func LimitHandlerOptions(cfg LimitConfig) []connect.HandlerOption {
+	if !cfg.Enabled {
+		return nil
+	}
+	return []connect.HandlerOption{connect.WithReadMaxBytes(cfg.MaxRequestBytes)}
+}
+
+func (i *limitInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if i.cfg.Enabled && !req.Spec().IsClient {
+			if err := i.limiter.allow(ctx, req.Peer()); err != nil {
+				return nil, err
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *limitInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *limitInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if !i.cfg.Enabled {
+			return next(ctx, conn)
+		}
+		return next(ctx, &limitedHandlerConn{
+			StreamingHandlerConn: conn,
+			ctx:                  ctx,
+			cfg:                  i.cfg,
+			limiter:              i.limiter,
+		})
+	}
+}
+
+// limitedHandlerConn takes a token for every received message and counts them.
+type limitedHandlerConn struct {
+	connect.StreamingHandlerConn
+	ctx      context.Context
+	cfg      LimitConfig
+	limiter  *rateLimiter
+	received int
+}
+
+func (c *limitedHandlerConn) Receive(msg interface{}) error {
+	if c.cfg.StreamMaxMessages > 0 && c.received >= c.cfg.StreamMaxMessages {
+		err := fmt.Errorf("%w: the stream received the most messages allowed, %d", ErrStreamExhausted, c.cfg.StreamMaxMessages)
+		return newConnectError(connect.CodeResourceExhausted, err, errorReasons[ErrStreamExhausted], map[string]string{"limit": "max_messages"})
+	}
+
+	if err := c.receive(msg); err != nil {
+		return err
+	}
+	c.received++
+
+	return c.limiter.allow(c.ctx, c.Peer())
+}
+
+// receive waits for the next message for the idle timeout at most, through a read deadline on the request body
+// set with the response controller stored by limitStreamIdleTime. The deadline is cleared once the message is
+// received so that the handling of the message isn't limited.
+func (c *limitedHandlerConn) receive(msg interface{}) error {
+	controller, ok := c.ctx.Value(responseControllerKey{}).(*http.ResponseController)
+	if c.cfg.StreamIdleTimeout <= 0 || !ok {
+		return c.StreamingHandlerConn.Receive(msg)
+	}
+
+	if err := controller.SetReadDeadline(time.Now().Add(c.cfg.StreamIdleTimeout)); err != nil {
+		return c.StreamingHandlerConn.Receive(msg)
+	}
+
+	err := c.StreamingHandlerConn.Receive(msg)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err := fmt.Errorf("%w: the stream received no message for %s", ErrStreamExhausted, c.cfg.StreamIdleTimeout)
+		return newConnectError(connect.CodeResourceExhausted, err, errorReasons[ErrStreamExhausted], map[string]string{"limit": "idle_timeout"})
+	}
+
+	if clearErr := controller.SetReadDeadline(time.Time{}); clearErr != nil && err == nil {
+		return clearErr
+	}
+	return err
+}
+
+type responseControllerKey struct{}
+
+// limitStreamIdleTime stores the response controller of the request in its context, the limit interceptor
+// sets the read deadlines of the streams with it. It has to wrap the connect handler directly, the controller
+// needs the writer of the server or one that unwraps to it.
+func limitStreamIdleTime(cfg LimitConfig, next http.Handler) http.Handler {
+	if !cfg.Enabled || cfg.StreamIdleTimeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// limitRequestBody limits the size of the body of a plain HTTP endpoint, writeHTTPError answers
+// 413 Request Entity Too Large when the handler reads past it.
+func limitRequestBody(cfg LimitConfig, next http.HandlerFunc) http.HandlerFunc {
+	if !cfg.Enabled {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.MaxRequestBytes))
+		next(w, r)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,38 +11,186 @@ import (
 	"time"
 
 	"buf.build/gen/go/mocha/remcall/connectrpc/go/donut/v1/donutv1connect"
+	"connectrpc.com/connect"
+	"connectrpc.com/grpcreflect"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
-	cfg, err := Get()
+	overrides := ConfigOverrides{}
+	configFile := flag.String("config", "", "YAML or TOML config file, defaults to $"+ConfigFileEnv)
+	flag.Var(overrides, "set", "override a config value such as database.host=localhost, can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), commandUsage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := Get(*configFile, overrides)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to get config")
 	}
 
+	// Run a one-off command instead of the server when one is given
+	args := flag.Args()
+	if len(args) > 0 && args[0] != "serve" {
+		if err := RunCommand(context.Background(), cfg, args); err != nil {
+			log.Fatal().Err(err).Msgf("failed to run %s command", args[0])
+		}
+		return
+	}
+
+	serve(cfg)
+}
+
+// serve runs the server and its background jobs until an interrupt or termination signal.
+func serve(cfg *Config) {
 	db, err := NewDatabaseInstance(cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to get database instance")
 	}
 
-	mux := http.NewServeMux()
+	if cfg.DatabaseConfig.AutoMigrate {
+		if err := Migrate(db); err != nil {
+			log.Fatal().Err(err).Msg("failed to migrate database")
+		}
+	}
+
+	metrics := NewMetrics()
+	if err := db.Use(NewGormMetricsPlugin(metrics)); err != nil {
+		log.Fatal().Err(err).Msg("failed to register database metrics")
+	}
+
+	shutdownTracing, err := SetupTracing(context.Background(), cfg.TracingConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to setup tracing")
+	}
+	if err := db.Use(NewGormTracingPlugin()); err != nil {
+		log.Fatal().Err(err).Msg("failed to register database tracing")
+	}
+
+	replicas, err := NewReplicaInstances(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get database replica instances")
+	}
+	for _, replica := range replicas {
+		if err := replica.Use(NewGormMetricsPlugin(metrics)); err != nil {
+			log.Fatal().Err(err).Msg("failed to register database replica metrics")
+		}
+		if err := replica.Use(NewGormTracingPlugin()); err != nil {
+			log.Fatal().Err(err).Msg("failed to register database replica tracing")
+		}
+	}
+
+	secrets, err := NewSecretBox(cfg.WebhookConfig.SecretKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create secret box")
+	}
 
 	// Create instances
-	repo := NewDonutRepository(db)
-	donut := NewDonutCall(repo)
-	handler := NewHandler(donut)
+	cache := NewCache(cfg)
+	repo := NewCachedDonutRepository(NewDonutRepository(db, replicas...), cache, metrics)
+	outboxRepo := NewOutboxRepository(db)
+	webhookRepo := NewWebhookRepository(db, secrets)
+	notificationRepo := NewNotificationRepository(db)
+	idempotencyRepo := NewIdempotencyRepository(db)
+	auditRepo := NewAuditRepository(db)
+	erasureRepo := NewCachedErasureRepository(NewErasureRepository(db), cache)
+	donut := NewTracedDonutCall(NewDonutCall(repo, outboxRepo, auditRepo, erasureRepo, metrics))
+	webhook := NewWebhookCall(webhookRepo, cfg.WebhookConfig)
+	notification := NewNotificationCall(notificationRepo, cfg.NotificationConfig)
+	audit := NewAuditCall(auditRepo)
 
-	mmPath, mmHandler := donutv1connect.NewMatchMakerServiceHandler(handler)
-	pPath, pHandler := donutv1connect.NewPeopleServiceHandler(handler)
+	mux := http.NewServeMux()
+	handler := NewHandler(donut, webhook, notification, audit, cfg)
+
+	limiter := newRateLimiter(cfg.LimitConfig)
+	interceptors := connect.WithInterceptors(
+		NewTracingInterceptor(),
+		NewMetricsInterceptor(metrics),
+		NewLimitInterceptor(limiter),
+		NewValidationInterceptor(cfg.ValidationConfig),
+		NewIdempotencyInterceptor(idempotencyRepo, cfg.IdempotencyConfig),
+		NewErrorInterceptor(),
+	)
+
+	options := append([]connect.HandlerOption{interceptors}, LimitHandlerOptions(cfg.LimitConfig)...)
+
+	mmPath, mmHandler := donutv1connect.NewMatchMakerServiceHandler(handler, options...)
+	pPath, pHandler := donutv1connect.NewPeopleServiceHandler(handler, options...)
+
+	mux.Handle(mmPath, limitStreamIdleTime(cfg.LimitConfig, mmHandler))
+	mux.Handle(pPath, limitStreamIdleTime(cfg.LimitConfig, pHandler))
+
+	if cfg.ApplicationConfig.Reflection {
+		reflector := grpcreflect.NewStaticReflector(donutv1connect.MatchMakerServiceName, donutv1connect.PeopleServiceName)
+		mux.Handle(grpcreflect.NewHandlerV1(reflector))
+		mux.Handle(grpcreflect.NewHandlerV1Alpha(reflector))
+	}
+
+	mux.HandleFunc("/export", requireAuthentication(limitRate(limiter, handler.ExportMatchMaker)))
+	mux.HandleFunc("/import", requireAuthentication(limitRate(limiter, limitRequestBody(cfg.LimitConfig, handler.ImportMatchMaker))))
+	mux.HandleFunc("/invitation", handler.GetPairInvitation)
+	mux.HandleFunc("/webhooks", requireAuthentication(limitRequestBody(cfg.LimitConfig, handler.Webhooks)))
+	mux.HandleFunc("/notifications", requireAuthentication(limitRequestBody(cfg.LimitConfig, handler.NotificationChannels)))
+	mux.HandleFunc("/notifications/deliveries", requireAuthentication(handler.GetNotificationDeliveries))
+	mux.HandleFunc("/audit", requireAuthentication(handler.GetAuditLogs))
+	mux.HandleFunc("/people/restore", requireAuthentication(limitRequestBody(cfg.LimitConfig, handler.RestorePeople)))
+	mux.HandleFunc("/people/erase", requireAuthentication(limitRequestBody(cfg.LimitConfig, handler.ErasePerson)))
+
+	rest := NewRESTHandler(handler,
+		NewRESTTracingMiddleware(),
+		NewRESTMetricsMiddleware(metrics),
+		NewRESTLimitMiddleware(limiter),
+		NewRESTIdempotencyMiddleware(idempotencyRepo, cfg.IdempotencyConfig),
+	)
+	mux.Handle(RESTPrefix, http.HandlerFunc(limitRequestBody(cfg.LimitConfig, rest.ServeHTTP)))
+
+	dashboard, err := NewDashboard(donut, cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create dashboard")
+	}
+	if dashboard != nil {
+		mux.HandleFunc(DashboardPath, limitRequestBody(cfg.LimitConfig, dashboard.ServeHTTP))
+	}
 
-	mux.Handle(mmPath, mmHandler)
-	mux.Handle(pPath, pHandler)
+	health := NewHealthChecker(db, cfg.HealthConfig, donutv1connect.MatchMakerServiceName, donutv1connect.PeopleServiceName)
+	hPath, hHandler := NewGRPCHealthHandler(health)
+
+	mux.Handle(hPath, hHandler)
+	mux.HandleFunc("/healthz", health.Live)
+	mux.HandleFunc("/readyz", health.Readiness)
+
+	if cfg.MetricsConfig.Enabled {
+		metrics.RegisterMatchMakerCollector(repo)
+		mux.Handle(cfg.MetricsConfig.Path, metrics.Handler())
+	}
 
 	server := &http.Server{
 		Addr:    cfg.ApplicationConfig.Address(),
-		Handler: h2c.NewHandler(mux, &http2.Server{}),
+		Handler: h2c.NewHandler(NewCORSMiddleware(cfg.CORSConfig, NewRequestMetadataMiddleware(NewAuthenticator(cfg.AuthConfig), mux)), &http2.Server{}),
+	}
+
+	webhooks := NewWebhookDispatcher(webhookRepo, cfg.WebhookConfig)
+	notifications := NewNotificationDispatcher(notificationRepo, donut, cfg.NotificationConfig)
+	sinks, err := NewEventSinks(cfg, webhooks, notifications)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create event sinks")
+	}
+
+	// Run the background jobs until the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	relay := NewOutboxRelay(outboxRepo, sinks, cfg.OutboxConfig)
+	go relay.Run(jobsCtx)
+	go RunIdempotencyPurge(jobsCtx, idempotencyRepo, cfg.IdempotencyConfig)
+	go RunRetention(jobsCtx, donut, cfg.RetentionConfig)
+	if containsString(cfg.OutboxConfig.Sinks, SinkWebhook) {
+		go webhooks.Run(jobsCtx)
+	}
+	if containsString(cfg.OutboxConfig.Sinks, SinkNotify) {
+		go notifications.RunReminders(jobsCtx)
 	}
 
 	log.Info().Msgf("server is listening on %s", cfg.ApplicationConfig.Address())
@@ -59,12 +209,22 @@ func main() {
 
 	log.Info().Msg("server is shutting down")
 
+	// Report not serving and keep serving while the load balancers take the instance out of rotation
+	health.Shutdown()
+	stopJobs()
+	time.Sleep(cfg.HealthConfig.DrainDelay)
+
 	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ApplicationConfig.GracefulShutdownTimeout))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ApplicationConfig.GracefulShutdownTimeout)*time.Second)
 	defer cancel()
 
 	// Shutdown the server gracefully
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal().Err(err).Msg("failed to shutdown server gracefully")
 	}
+
+	// Flush the spans that are still buffered
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to shutdown tracing")
+	}
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	metricsNamespace = "donut"
+
+	gormMetricsStartKey = "donut:metrics_start"
+)
+
+type MetricsConfig struct {
+	Enabled bool   `env:"METRICS_ENABLED" envDefault:"true"`
+	Path    string `env:"METRICS_PATH" envDefault:"/metrics"`
+}
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	rpcRequests     *prometheus.CounterVec
+	rpcDuration     *prometheus.HistogramVec
+	pairingDuration prometheus.Histogram
+	pairGroupSize   prometheus.Histogram
+	queryDuration   *prometheus.HistogramVec
+	cacheRequests   *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "rpc",
+			Name:      "requests_total",
+			Help:      "Number of handled RPCs by procedure and connect code.",
+		}, []string{"procedure", "code"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "rpc",
+			Name:      "duration_seconds",
+			Help:      "Latency of handled RPCs by procedure, streams are measured until they are closed.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"procedure"}),
+		pairingDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "pairing",
+			Name:      "duration_seconds",
+			Help:      "Time spent pairing the people of a match maker.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+		pairGroupSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "pairing",
+			Name:      "group_size",
+			Help:      "Number of people in each group created by pairing.",
+			Buckets:   []float64{1, 2, 3, 4},
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "database",
+			Name:      "query_duration_seconds",
+			Help:      "Latency of database statements by operation and table.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation", "table"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Number of repository cache lookups by cache and result, hit or miss.",
+		}, []string{"cache", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.rpcRequests,
+		m.rpcDuration,
+		m.pairingDuration,
+		m.pairGroupSize,
+		m.queryDuration,
+		m.cacheRequests,
+	)
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObservePairing(startTime time.Time, groups MatchMap) {
+	if m == nil {
+		return
+	}
+
+	m.pairingDuration.Observe(time.Since(startTime).Seconds())
+	for _, people := range groups {
+		m.pairGroupSize.Observe(float64(len(people)))
+	}
+}
+
+// ObserveCache counts a lookup of the cache, the hit rate is the share of the hits in the lookups.
+func (m *Metrics) ObserveCache(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+func (m *Metrics) observeRPC(procedure string, startTime time.Time, err error) {
+	code := "ok"
+	if err != nil && !errors.Is(err, context.Canceled) {
+		code = connect.CodeOf(err).String()
+	}
+
+	m.rpcRequests.WithLabelValues(procedure, code).Inc()
+	m.rpcDuration.WithLabelValues(procedure).Observe(time.Since(startTime).Seconds())
+}
+
+// RegisterMatchMakerCollector exposes the number of match makers by status, counted on every scrape.
+func (m *Metrics) RegisterMatchMakerCollector(repo DonutRepository) {
+	m.registry.MustRegister(&matchMakerCollector{
+		repo: repo,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "matchmaker", "count"),
+			"Number of match makers by status.",
+			[]string{"status"},
+			nil,
+		),
+	})
+}
+
+type matchMakerCollector struct {
+	repo DonutRepository
+	desc *prometheus.Desc
+}
+
+func (c *matchMakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *matchMakerCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counts, err := c.repo.CountMatchMakersByStatus(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count match makers by status")
+		return
+	}
+
+	for _, status := range []MatchMakerStatus{
+		MatchMakerStatusPending,
+		MatchMakerStatusRunning,
+		MatchMakerStatusFinished,
+		MatchMakerStatusStopped,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(counts[status]), string(status))
+	}
+}
+
+type metricsInterceptor struct {
+	metrics *Metrics
+}
+
+// NewMetricsInterceptor counts and times every procedure served by the connect handlers.
+func NewMetricsInterceptor(metrics *Metrics) connect.Interceptor {
+	return &metricsInterceptor{
+		metrics: metrics,
+	}
+}
+
+func (i *metricsInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		startTime := time.Now()
+		resp, err := next(ctx, req)
+		i.metrics.observeRPC(req.Spec().Procedure, startTime, err)
+		return resp, err
+	}
+}
+
+// NewRESTMetricsMiddleware counts the requests of the REST API and their duration with those of the procedures.
+func NewRESTMetricsMiddleware(metrics *Metrics) RESTMiddleware {
+	return func(procedure string, next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			startTime := time.Now()
+			recorder := &restResponseRecorder{ResponseWriter: w}
+			next(recorder, r)
+			metrics.observeRPC(procedure, startTime, recorder.err())
+		}
+	}
+}
+
+func (i *metricsInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *metricsInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		startTime := time.Now()
+		err := next(ctx, conn)
+		i.metrics.observeRPC(conn.Spec().Procedure, startTime, err)
+		return err
+	}
+}
+
+type gormMetricsPlugin struct {
+	metrics *Metrics
+}
+
+// NewGormMetricsPlugin times every statement executed through gorm.
+func NewGormMetricsPlugin(metrics *Metrics) gorm.Plugin {
+	return &gormMetricsPlugin{
+		metrics: metrics,
+	}
+}
+
+func (p *gormMetricsPlugin) Name() string {
+	return "donut:metrics"
+}
+
+func (p *gormMetricsPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("gorm:create").Register("donut:metrics_before_create", p.before),
+		callback.Create().After("gorm:create").Register("donut:metrics_after_create", p.after("create")),
+		callback.Query().Before("gorm:query").Register("donut:metrics_before_query", p.before),
+		callback.Query().After("gorm:query").Register("donut:metrics_after_query", p.after("query")),
+		callback.Update().Before("gorm:update").Register("donut:metrics_before_update", p.before),
+		callback.Update().After("gorm:update").Register("donut:metrics_after_update", p.after("update")),
+		callback.Delete().Before("gorm:delete").Register("donut:metrics_before_delete", p.before),
+		callback.Delete().After("gorm:delete").Register("donut:metrics_after_delete", p.after("delete")),
+		callback.Row().Before("gorm:row").Register("donut:metrics_before_row", p.before),
+		callback.Row().After("gorm:row").Register("donut:metrics_after_row", p.after("row")),
+		callback.Raw().Before("gorm:raw").Register("donut:metrics_before_raw", p.before),
+		callback.Raw().After("gorm:raw").Register("donut:metrics_after_raw", p.after("raw")),
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *gormMetricsPlugin) before(db *gorm.DB) {
+	db.InstanceSet(gormMetricsStartKey, time.Now())
+}
+
+func (p *gormMetricsPlugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(gormMetricsStartKey)
+		if !ok {
+			return
+		}
+
+		startTime, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		p.metrics.queryDuration.WithLabelValues(operation, db.Statement.Table).Observe(time.Since(startTime).Seconds())
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SchemaVersion is the version of the tables owned by the service, bump it whenever Migrate changes them.
+const SchemaVersion = 8
+
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migration"
+}
+
+// Migrate creates or updates the tables owned by the service and records the schema version.
+// The matchmaker and matchmaker_user tables are managed outside of the service and are left untouched.
+func Migrate(db *gorm.DB) error {
+	err := db.AutoMigrate(
+		&SchemaMigration{},
+		&OutboxEvent{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&WebhookDeadLetter{},
+		&NotificationChannel{},
+		&NotificationDelivery{},
+		&IdempotencyKey{},
+		&AuditLog{},
+		&MatchMakerArchive{},
+		&MatchMakerUserArchive{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// The idempotency keys are scoped per caller since version 8, their former index kept them unique across callers
+	if db.Migrator().HasIndex(&IdempotencyKey{}, "idx_idempotency_key_procedure") {
+		if err := db.Migrator().DropIndex(&IdempotencyKey{}, "idx_idempotency_key_procedure"); err != nil {
+			return err
+		}
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&SchemaMigration{Version: SchemaVersion}).Error
+}
+
+// CurrentSchemaVersion returns the latest schema version recorded by Migrate.
+func CurrentSchemaVersion(ctx context.Context, db *gorm.DB) (int, error) {
+	var version int
+	err := db.WithContext(ctx).Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
@@ -0,0 +1,792 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	NotifierDiscord = "discord"
+	NotifierSlack   = "slack"
+
+	ChannelSerialColumn = "channel_serial"
+	DeliveryKeyColumn   = "delivery_key"
+	ErrorColumn         = "error"
+
+	maxNotificationDeliveries = 200
+
+	// discordMaxContent is the most characters Discord accepts in the content of a message
+	discordMaxContent = 2000
+)
+
+// errNotificationUndeliverable marks the failures that another attempt won't fix, such as a template that
+// doesn't render, they are tracked but the event is not relayed again for them.
+var errNotificationUndeliverable = errors.New("notification is undeliverable")
+
+type NotificationConfig struct {
+	Timeout     time.Duration `env:"NOTIFICATION_TIMEOUT" envDefault:"10s"`
+	MaxAttempts int           `env:"NOTIFICATION_MAX_ATTEMPTS" envDefault:"3"`
+	Backoff     time.Duration `env:"NOTIFICATION_BACKOFF" envDefault:"1s"`
+	// The running match makers are reminded once of the groups that haven't called yet, ReminderBefore their end
+	ReminderBefore   time.Duration `env:"NOTIFICATION_REMINDER_BEFORE" envDefault:"24h"`
+	ReminderInterval time.Duration `env:"NOTIFICATION_REMINDER_INTERVAL" envDefault:"1h"`
+	// AllowedHosts are the hosts of the incoming webhooks of the providers, their subdomains are allowed too.
+	// The channels are posted to by the server, so any other host is refused
+	AllowedHosts []string `env:"NOTIFICATION_ALLOWED_HOSTS" envSeparator:"," envDefault:"discord.com,discordapp.com,hooks.slack.com"`
+}
+
+// validateNotificationURL checks that the URL of a channel is an https URL of one of the allowed hosts.
+func validateNotificationURL(rawURL string, allowedHosts []string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" {
+		return fmt.Errorf("url must be an https url")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("url host %s is not one of %s", host, strings.Join(allowedHosts, ", "))
+}
+
+type NotificationKind string
+
+const (
+	NotificationKindPairing  NotificationKind = "pairing"
+	NotificationKindReminder NotificationKind = "reminder"
+	NotificationKindFinish   NotificationKind = "finish"
+)
+
+var notificationKinds = []NotificationKind{
+	NotificationKindPairing,
+	NotificationKindReminder,
+	NotificationKindFinish,
+}
+
+// notificationEvents are the events that notify the channels, the reminders are sent by RunReminders instead.
+var notificationEvents = map[EventType]NotificationKind{
+	EventTypeMatchMakerStarted: NotificationKindPairing,
+	EventTypeMatchMakerStopped: NotificationKindFinish,
+}
+
+var notificationTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// defaultNotificationTemplates render the messages of the channels without a template of their own.
+var defaultNotificationTemplates = map[NotificationKind]string{
+	NotificationKindPairing: `{{.MatchMaker.Name}} has started, {{len .MatchMaker.Pairs}} groups have until {{.MatchMaker.EndTime.Format "Mon, 2 Jan 2006"}} to call:
+{{range .MatchMaker.Pairs}}- {{join .References ", "}}
+{{end}}`,
+	NotificationKindReminder: `{{.MatchMaker.Name}} ends on {{.MatchMaker.EndTime.Format "Mon, 2 Jan 2006"}}, {{len .Pending}} groups haven't called yet:
+{{range .Pending}}- {{join .References ", "}}
+{{end}}`,
+	NotificationKindFinish: `{{.MatchMaker.Name}} has finished, {{len .Finished}} of {{len .MatchMaker.Pairs}} groups called.`,
+}
+
+// NotificationData is given to the templates, Finished are the pairs whose people all called and Pending the others.
+type NotificationData struct {
+	Kind       NotificationKind
+	MatchMaker *MatchMakerExport
+	Finished   []PairExport
+	Pending    []PairExport
+}
+
+func NewNotificationData(kind NotificationKind, info *MatchMakerInformation) *NotificationData {
+	export := NewMatchMakerExport(info)
+
+	unfinished := make(map[string]bool)
+	for _, person := range export.People {
+		if person.Status != MatchMakerUserStatusFinished {
+			unfinished[person.PairSerial] = true
+		}
+	}
+
+	data := &NotificationData{
+		Kind:       kind,
+		MatchMaker: export,
+		Finished:   make([]PairExport, 0),
+		Pending:    make([]PairExport, 0),
+	}
+	for _, pair := range export.Pairs {
+		if unfinished[pair.Serial] {
+			data.Pending = append(data.Pending, pair)
+		} else {
+			data.Finished = append(data.Finished, pair)
+		}
+	}
+
+	return data
+}
+
+// Notifier posts a message to a chat channel through its incoming webhook.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, webhookURL, text string) error
+}
+
+// NewNotifiers returns the notifiers by the provider of the channels.
+func NewNotifiers(client *http.Client) map[string]Notifier {
+	return map[string]Notifier{
+		NotifierDiscord: &discordNotifier{client: client},
+		NotifierSlack:   &slackNotifier{client: client},
+	}
+}
+
+type discordNotifier struct {
+	client *http.Client
+}
+
+func (n *discordNotifier) Name() string {
+	return NotifierDiscord
+}
+
+// Notify sends the message without allowing any mention, a reference such as @everyone doesn't ping the server.
+func (n *discordNotifier) Notify(ctx context.Context, webhookURL, text string) error {
+	if runes := []rune(text); len(runes) > discordMaxContent {
+		text = string(runes[:discordMaxContent-1]) + "…"
+	}
+
+	return postNotification(ctx, n.client, webhookURL, map[string]interface{}{
+		"content": text,
+		"allowed_mentions": map[string]interface{}{
+			"parse": []string{},
+		},
+	})
+}
+
+type slackNotifier struct {
+	client *http.Client
+}
+
+func (n *slackNotifier) Name() string {
+	return NotifierSlack
+}
+
+// Notify escapes the control characters of Slack, a reference such as <!channel> is shown as written.
+func (n *slackNotifier) Notify(ctx context.Context, webhookURL, text string) error {
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+
+	return postNotification(ctx, n.client, webhookURL, map[string]interface{}{
+		"text": text,
+	})
+}
+
+func postNotification(ctx context.Context, client *http.Client, webhookURL string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// NotificationChannelEntity is a chat channel notified of the match makers, all of them
+// or only the one of MatchMakerSerial. The templates replace the default message of their kind.
+type NotificationChannelEntity struct {
+	Serial           string
+	Provider         string
+	URL              string
+	MatchMakerSerial string
+	Templates        map[NotificationKind]string
+	Active           bool
+}
+
+type NotificationChannelEntityOption func(*NotificationChannelEntity)
+
+func WithNotificationChannelEntityProvider(provider string) NotificationChannelEntityOption {
+	return func(n *NotificationChannelEntity) {
+		n.Provider = provider
+	}
+}
+
+func WithNotificationChannelEntityURL(url string) NotificationChannelEntityOption {
+	return func(n *NotificationChannelEntity) {
+		n.URL = url
+	}
+}
+
+func WithNotificationChannelEntityMatchMakerSerial(matchMakerSerial string) NotificationChannelEntityOption {
+	return func(n *NotificationChannelEntity) {
+		n.MatchMakerSerial = matchMakerSerial
+	}
+}
+
+func WithNotificationChannelEntityTemplates(templates map[NotificationKind]string) NotificationChannelEntityOption {
+	return func(n *NotificationChannelEntity) {
+		n.Templates = templates
+	}
+}
+
+func (n *NotificationChannelEntity) Build(options ...NotificationChannelEntityOption) *NotificationChannelEntity {
+	n.Serial = GenerateSerial()
+	n.Active = true
+
+	for _, opt := range options {
+		opt(n)
+	}
+
+	return n
+}
+
+func (n *NotificationChannelEntity) Error() error {
+	if n.Serial == "" {
+		return fmt.Errorf("serial is empty")
+	}
+
+	if n.Provider != NotifierDiscord && n.Provider != NotifierSlack {
+		return fmt.Errorf("provider must be %s or %s", NotifierDiscord, NotifierSlack)
+	}
+
+	if err := validateOutboundURL(n.URL, true); err != nil {
+		return err
+	}
+
+	for kind, text := range n.Templates {
+		if _, ok := defaultNotificationTemplates[kind]; !ok {
+			return fmt.Errorf("template %s is not one of %s", kind, joinNotificationKinds())
+		}
+		if _, err := parseNotificationTemplate(kind, text); err != nil {
+			return fmt.Errorf("template %s: %w", kind, err)
+		}
+	}
+
+	return nil
+}
+
+// Render executes the template of the channel for the kind of the data, or the default one.
+func (n *NotificationChannelEntity) Render(data *NotificationData) (string, error) {
+	text, ok := n.Templates[data.Kind]
+	if !ok || text == "" {
+		text = defaultNotificationTemplates[data.Kind]
+	}
+
+	t, err := parseNotificationTemplate(data.Kind, text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func parseNotificationTemplate(kind NotificationKind, text string) (*template.Template, error) {
+	return template.New(string(kind)).Funcs(notificationTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func joinNotificationKinds() string {
+	kinds := make([]string, 0, len(notificationKinds))
+	for _, kind := range notificationKinds {
+		kinds = append(kinds, string(kind))
+	}
+	return strings.Join(kinds, ", ")
+}
+
+type NotificationChannelEntities []*NotificationChannelEntity
+
+type NotificationChannel struct {
+	ID               uint64 `gorm:"primaryKey;autoIncrement"`
+	Serial           string `gorm:"uniqueIndex;size:36"`
+	Provider         string `gorm:"size:16"`
+	URL              string
+	MatchMakerSerial string `gorm:"column:matchmaker_serial;index;size:36"`
+	Templates        string `gorm:"type:text"`
+	Active           bool
+	CreatedAt        time.Time `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime"`
+}
+
+func (NotificationChannel) TableName() string {
+	return "notification_channel"
+}
+
+func (NotificationChannel) FromEntity(entity *NotificationChannelEntity) (*NotificationChannel, error) {
+	if entity == nil {
+		return nil, nil
+	}
+
+	templates, err := json.Marshal(entity.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationChannel{
+		Serial:           entity.Serial,
+		Provider:         entity.Provider,
+		URL:              entity.URL,
+		MatchMakerSerial: entity.MatchMakerSerial,
+		Templates:        string(templates),
+		Active:           entity.Active,
+	}, nil
+}
+
+func (n *NotificationChannel) ToEntity() *NotificationChannelEntity {
+	if n == nil {
+		return nil
+	}
+
+	templates := make(map[NotificationKind]string)
+	if n.Templates != "" {
+		if err := json.Unmarshal([]byte(n.Templates), &templates); err != nil {
+			log.Warn().Err(err).Str("channel", n.Serial).Msg("ignoring invalid notification templates")
+		}
+	}
+
+	return &NotificationChannelEntity{
+		Serial:           n.Serial,
+		Provider:         n.Provider,
+		URL:              n.URL,
+		MatchMakerSerial: n.MatchMakerSerial,
+		Templates:        templates,
+		Active:           n.Active,
+	}
+}
+
+type NotificationChannels []*NotificationChannel
+
+func (n NotificationChannels) ToEntities() NotificationChannelEntities {
+	var entities NotificationChannelEntities
+	for _, channel := range n {
+		if channel == nil {
+			continue
+		}
+		entities = append(entities, channel.ToEntity())
+	}
+	return entities
+}
+
+type NotificationStatus string
+
+const (
+	NotificationStatusDelivered NotificationStatus = "delivered"
+	NotificationStatusFailed    NotificationStatus = "failed"
+)
+
+// NotificationDelivery tracks the notification of a channel for an event, or for the reminder of a match maker.
+// A delivered notification is never sent again, a failed one is tried again the next time it is due.
+// The message itself is not kept, it holds the references of the people.
+type NotificationDelivery struct {
+	ID               uint64 `gorm:"primaryKey;autoIncrement"`
+	ChannelSerial    string `gorm:"uniqueIndex:idx_notification_delivery;size:36"`
+	DeliveryKey      string `gorm:"uniqueIndex:idx_notification_delivery;size:64"`
+	MatchMakerSerial string `gorm:"column:matchmaker_serial;index;size:36"`
+	Kind             NotificationKind
+	Status           NotificationStatus
+	Attempts         int
+	Error            string     `gorm:"type:text"`
+	DeliveredAt      *time.Time `gorm:"index"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime"`
+}
+
+func (NotificationDelivery) TableName() string {
+	return "notification_delivery"
+}
+
+type NotificationDeliveries []*NotificationDelivery
+
+type NotificationDeliveryFilter struct {
+	ChannelSerial    string
+	MatchMakerSerial string
+	Status           NotificationStatus
+	Limit            int
+}
+
+type notificationRepository struct {
+	db *gorm.DB
+}
+
+type NotificationRepository interface {
+	CreateChannel(ctx context.Context, channel *NotificationChannelEntity) error
+	DeleteChannelBySerial(ctx context.Context, serial string) error
+	GetChannels(ctx context.Context) (NotificationChannelEntities, error)
+	GetActiveChannels(ctx context.Context, matchMakerSerial string) (NotificationChannelEntities, error)
+
+	GetDelivery(ctx context.Context, channelSerial, deliveryKey string) (*NotificationDelivery, error)
+	SaveDelivery(ctx context.Context, delivery *NotificationDelivery) error
+	GetDeliveries(ctx context.Context, filter NotificationDeliveryFilter) (NotificationDeliveries, error)
+}
+
+func NewNotificationRepository(db *gorm.DB) NotificationRepository {
+	return &notificationRepository{
+		db: db,
+	}
+}
+
+func (r *notificationRepository) CreateChannel(ctx context.Context, channel *NotificationChannelEntity) error {
+	model, err := NotificationChannel{}.FromEntity(channel)
+	if err != nil {
+		return err
+	}
+	return transactionOrDB(ctx, r.db).Create(model).Error
+}
+
+func (r *notificationRepository) DeleteChannelBySerial(ctx context.Context, serial string) error {
+	q := fmt.Sprintf("%s = ?", SerialColumn)
+	return transactionOrDB(ctx, r.db).Where(q, serial).Delete(&NotificationChannel{}).Error
+}
+
+func (r *notificationRepository) GetChannels(ctx context.Context) (NotificationChannelEntities, error) {
+	var channels NotificationChannels
+	err := transactionOrDB(ctx, r.db).Order("id").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels.ToEntities(), nil
+}
+
+// GetActiveChannels returns the active channels of every match maker and those of the match maker.
+func (r *notificationRepository) GetActiveChannels(ctx context.Context, matchMakerSerial string) (NotificationChannelEntities, error) {
+	var channels NotificationChannels
+	q := fmt.Sprintf("%s = ? AND (%s = ? OR %s = ?)", ActiveColumn, MatchMakerSerialColumn, MatchMakerSerialColumn)
+	err := transactionOrDB(ctx, r.db).Where(q, true, "", matchMakerSerial).Order("id").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	return channels.ToEntities(), nil
+}
+
+// GetDelivery returns the delivery of the channel for the key, or nil when it was never attempted.
+func (r *notificationRepository) GetDelivery(ctx context.Context, channelSerial, deliveryKey string) (*NotificationDelivery, error) {
+	var delivery NotificationDelivery
+	q := fmt.Sprintf("%s = ? AND %s = ?", ChannelSerialColumn, DeliveryKeyColumn)
+	err := transactionOrDB(ctx, r.db).Where(q, channelSerial, deliveryKey).First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// SaveDelivery creates the delivery or updates the outcome of the one of the same channel and key.
+func (r *notificationRepository) SaveDelivery(ctx context.Context, delivery *NotificationDelivery) error {
+	return transactionOrDB(ctx, r.db).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: ChannelSerialColumn}, {Name: DeliveryKeyColumn}},
+			DoUpdates: clause.AssignmentColumns([]string{StatusColumn, AttemptsColumn, ErrorColumn, DeliveredAtColumn, UpdatedAtColumn}),
+		}).
+		Create(delivery).
+		Error
+}
+
+func (r *notificationRepository) GetDeliveries(ctx context.Context, filter NotificationDeliveryFilter) (NotificationDeliveries, error) {
+	query := transactionOrDB(ctx, r.db)
+
+	if filter.ChannelSerial != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", ChannelSerialColumn), filter.ChannelSerial)
+	}
+	if filter.MatchMakerSerial != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", MatchMakerSerialColumn), filter.MatchMakerSerial)
+	}
+	if filter.Status != "" {
+		query = query.Where(fmt.Sprintf("%s = ?", StatusColumn), filter.Status)
+	}
+
+	var deliveries NotificationDeliveries
+	err := query.Order(fmt.Sprintf("%s DESC", IDColumn)).Limit(filter.Limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+type notificationCall struct {
+	repo NotificationRepository
+	cfg  NotificationConfig
+}
+
+type NotificationCall interface {
+	CreateChannel(ctx context.Context, channel *NotificationChannelEntity) (string, error)
+	DeleteChannel(ctx context.Context, serial string) error
+	GetChannels(ctx context.Context) (NotificationChannelEntities, error)
+	GetDeliveries(ctx context.Context, filter NotificationDeliveryFilter) (NotificationDeliveries, error)
+}
+
+func NewNotificationCall(notificationRepository NotificationRepository, cfg NotificationConfig) NotificationCall {
+	return &notificationCall{
+		repo: notificationRepository,
+		cfg:  cfg,
+	}
+}
+
+func (nc *notificationCall) CreateChannel(ctx context.Context, channel *NotificationChannelEntity) (string, error) {
+	if err := channel.Error(); err != nil {
+		return "", err
+	}
+	if err := validateNotificationURL(channel.URL, nc.cfg.AllowedHosts); err != nil {
+		return "", err
+	}
+
+	err := nc.repo.CreateChannel(ctx, channel)
+	if err != nil {
+		return "", err
+	}
+
+	return channel.Serial, nil
+}
+
+func (nc *notificationCall) DeleteChannel(ctx context.Context, serial string) error {
+	if serial == "" {
+		return fmt.Errorf("serial is empty")
+	}
+
+	return nc.repo.DeleteChannelBySerial(ctx, serial)
+}
+
+func (nc *notificationCall) GetChannels(ctx context.Context) (NotificationChannelEntities, error) {
+	return nc.repo.GetChannels(ctx)
+}
+
+func (nc *notificationCall) GetDeliveries(ctx context.Context, filter NotificationDeliveryFilter) (NotificationDeliveries, error) {
+	if filter.Limit <= 0 || filter.Limit > maxNotificationDeliveries {
+		filter.Limit = maxNotificationDeliveries
+	}
+	return nc.repo.GetDeliveries(ctx, filter)
+}
+
+// NotificationDispatcher notifies the channels when the match makers are paired and finished, as a sink of the outbox,
+// and reminds them of the groups that haven't called before the end. Every delivery is retried with exponential backoff
+// and its outcome is tracked per channel. A delivery still failing fails the event, which the outbox relays again
+// later, and a notification delivered once is not sent again then.
+type NotificationDispatcher struct {
+	repo      NotificationRepository
+	svc       DonutCall
+	notifiers map[string]Notifier
+	cfg       NotificationConfig
+}
+
+func NewNotificationDispatcher(repo NotificationRepository, svc DonutCall, cfg NotificationConfig) *NotificationDispatcher {
+	return &NotificationDispatcher{
+		repo:      repo,
+		svc:       svc,
+		notifiers: NewNotifiers(newOutboundHTTPClient(cfg.Timeout, false)),
+		cfg:       cfg,
+	}
+}
+
+func (d *NotificationDispatcher) Name() string {
+	return SinkNotify
+}
+
+// Publish returns an error when the channels or the match maker could not be read, or when a channel could not be
+// notified, so that the outbox relays the event again. The failures no attempt would fix are only tracked.
+func (d *NotificationDispatcher) Publish(ctx context.Context, event *EventEntity) error {
+	kind, ok := notificationEvents[event.Type]
+	if !ok {
+		return nil
+	}
+
+	return d.notify(ctx, kind, event.MatchMakerSerial, event.Serial)
+}
+
+// RunReminders reminds the running match makers every interval until the context is done.
+func (d *NotificationDispatcher) RunReminders(ctx context.Context) {
+	ctx = WithRequestMetadata(ctx, RequestMetadata{Actor: SystemActor})
+
+	ticker := time.NewTicker(d.cfg.ReminderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := d.Remind(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to remind match makers")
+		}
+	}
+}
+
+// Remind notifies the channels of the running match makers ending within the reminder window,
+// once per match maker.
+func (d *NotificationDispatcher) Remind(ctx context.Context) error {
+	matchMakers, err := d.svc.ListMatchMakers(ctx, []MatchMakerStatus{MatchMakerStatusRunning})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	now := time.Now()
+	for _, matchMaker := range matchMakers {
+		if matchMaker == nil {
+			continue
+		}
+
+		endTime := matchMaker.StartTime.Add(matchMaker.Duration)
+		if now.Before(endTime.Add(-d.cfg.ReminderBefore)) || !now.Before(endTime) {
+			continue
+		}
+
+		// A failed reminder is sent again on the next interval, the others are not held up by it
+		if err := d.notify(ctx, NotificationKindReminder, matchMaker.Serial, "reminder:"+matchMaker.Serial); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *NotificationDispatcher) notify(ctx context.Context, kind NotificationKind, matchMakerSerial, deliveryKey string) error {
+	channels, err := d.repo.GetActiveChannels(ctx, matchMakerSerial)
+	if err != nil || len(channels) == 0 {
+		return err
+	}
+
+	info, err := d.svc.GetInformation(ctx, matchMakerSerial)
+	if errors.Is(err, ErrNotFound) {
+		log.Warn().Str("matchmaker", matchMakerSerial).Msg("match maker to notify is not found")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data := NewNotificationData(kind, info)
+	if kind == NotificationKindReminder && len(data.Pending) == 0 {
+		return nil
+	}
+
+	// Every channel is attempted even when one fails, the delivered ones are skipped when the event is relayed again
+	var errs []error
+	for _, channel := range channels {
+		if channel == nil {
+			continue
+		}
+		if err := d.deliver(ctx, channel, data, deliveryKey); err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %w", channel.Serial, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver sends the notification to the channel unless it was delivered already and tracks the outcome. It returns
+// the error of a failed delivery unless no attempt would fix it.
+func (d *NotificationDispatcher) deliver(ctx context.Context, channel *NotificationChannelEntity, data *NotificationData, deliveryKey string) error {
+	previous, err := d.repo.GetDelivery(ctx, channel.Serial, deliveryKey)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.Status == NotificationStatusDelivered {
+		return nil
+	}
+
+	delivery := &NotificationDelivery{
+		ChannelSerial:    channel.Serial,
+		DeliveryKey:      deliveryKey,
+		MatchMakerSerial: data.MatchMaker.Serial,
+		Kind:             data.Kind,
+		Status:           NotificationStatusDelivered,
+	}
+	if previous != nil {
+		delivery.Attempts = previous.Attempts
+	}
+
+	attempts, err := d.notifyWithRetry(ctx, channel, data)
+	delivery.Attempts += attempts
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Warn().Err(err).
+			Str("channel", channel.Serial).
+			Str("matchmaker", data.MatchMaker.Serial).
+			Str("kind", string(data.Kind)).
+			Msg("notification delivery failed")
+
+		delivery.Status = NotificationStatusFailed
+		delivery.Error = err.Error()
+	} else {
+		deliveredAt := time.Now()
+		delivery.DeliveredAt = &deliveredAt
+	}
+
+	if saveErr := d.repo.SaveDelivery(ctx, delivery); saveErr != nil {
+		return saveErr
+	}
+	if err != nil && !errors.Is(err, errNotificationUndeliverable) {
+		return err
+	}
+	return nil
+}
+
+func (d *NotificationDispatcher) notifyWithRetry(ctx context.Context, channel *NotificationChannelEntity, data *NotificationData) (int, error) {
+	notifier, ok := d.notifiers[channel.Provider]
+	if !ok {
+		return 0, fmt.Errorf("%w: unsupported notification provider: %s", errNotificationUndeliverable, channel.Provider)
+	}
+
+	// The channels created before the hosts were restricted are checked when they are notified
+	if err := validateNotificationURL(channel.URL, d.cfg.AllowedHosts); err != nil {
+		return 0, fmt.Errorf("%w: %v", errNotificationUndeliverable, err)
+	}
+
+	// A template that doesn't render won't render on the next attempt either
+	text, err := channel.Render(data)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to render the %s template: %v", errNotificationUndeliverable, data.Kind, err)
+	}
+
+	backoff := d.cfg.Backoff
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		err = notifier.Notify(ctx, channel.URL, text)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if attempt == d.cfg.MaxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return d.cfg.MaxAttempts, err
+}
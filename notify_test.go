@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWebhookServer is the incoming webhook of a chat provider, it answers the status it is given and
+// records the bodies it receives.
+type testWebhookServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	bodies []map[string]interface{}
+}
+
+func newTestWebhookServer(t *testing.T) *testWebhookServer {
+	t.Helper()
+
+	s := &testWebhookServer{status: http.StatusNoContent}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode the notification: %v", err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, body)
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testWebhookServer) respond(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *testWebhookServer) received() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.bodies...)
+}
+
+// testNotificationRepository keeps the deliveries in memory, the other methods are not used by the dispatcher.
+type testNotificationRepository struct {
+	NotificationRepository
+
+	deliveries map[string]NotificationDelivery
+}
+
+func (r *testNotificationRepository) GetDelivery(ctx context.Context, channelSerial, deliveryKey string) (*NotificationDelivery, error) {
+	delivery, ok := r.deliveries[channelSerial+"/"+deliveryKey]
+	if !ok {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (r *testNotificationRepository) SaveDelivery(ctx context.Context, delivery *NotificationDelivery) error {
+	r.deliveries[delivery.ChannelSerial+"/"+delivery.DeliveryKey] = *delivery
+	return nil
+}
+
+func newTestNotificationDispatcher(server *testWebhookServer, cfg NotificationConfig) (*NotificationDispatcher, *testNotificationRepository) {
+	repo := &testNotificationRepository{deliveries: make(map[string]NotificationDelivery)}
+	cfg.AllowedHosts = []string{"127.0.0.1"}
+
+	d := NewNotificationDispatcher(repo, nil, cfg)
+	// The test server is on the loopback, which the outbound client refuses
+	d.notifiers = NewNotifiers(server.Client())
+	return d, repo
+}
+
+func newTestNotificationData() *NotificationData {
+	return NewNotificationData(NotificationKindPairing, &MatchMakerInformation{
+		MatchMaker: &MatchMakerEntity{
+			Serial:    GenerateSerial(),
+			Name:      "Coffee",
+			StartTime: time.Now(),
+			Duration:  7 * Day,
+		},
+		Pairs: make(MatchMap),
+	})
+}
+
+func TestDiscordNotify(t *testing.T) {
+	server := newTestWebhookServer(t)
+	notifier := NewNotifiers(server.Client())[NotifierDiscord]
+
+	text := "@everyone " + strings.Repeat("é", discordMaxContent)
+	if err := notifier.Notify(context.Background(), server.URL, text); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one notification but got %d", len(bodies))
+	}
+
+	content, _ := bodies[0]["content"].(string)
+	if runes := []rune(content); len(runes) != discordMaxContent || runes[len(runes)-1] != '…' {
+		t.Errorf("expected the content to be truncated to %d characters ending with …, got %d", discordMaxContent, len(runes))
+	}
+
+	allowedMentions, _ := bodies[0]["allowed_mentions"].(map[string]interface{})
+	if parse, ok := allowedMentions["parse"].([]interface{}); !ok || len(parse) != 0 {
+		t.Errorf("expected no mention to be allowed but got %v", bodies[0]["allowed_mentions"])
+	}
+}
+
+func TestSlackNotify(t *testing.T) {
+	server := newTestWebhookServer(t)
+	notifier := NewNotifiers(server.Client())[NotifierSlack]
+
+	if err := notifier.Notify(context.Background(), server.URL, "<!channel> Ann & <@U123>"); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := server.received()
+	if len(bodies) != 1 {
+		t.Fatalf("expected one notification but got %d", len(bodies))
+	}
+
+	expected := "&lt;!channel&gt; Ann &amp; &lt;@U123&gt;"
+	if text := bodies[0]["text"]; text != expected {
+		t.Errorf("expected the text %q but got %q", expected, text)
+	}
+}
+
+// TestNotificationDeliveryRetries fails the deliveries of a channel, each one is attempted
+// NOTIFICATION_MAX_ATTEMPTS times and fails the event, until one succeeds and is never sent again.
+func TestNotificationDeliveryRetries(t *testing.T) {
+	const maxAttempts = 3
+
+	server := newTestWebhookServer(t)
+	server.respond(http.StatusInternalServerError)
+
+	d, repo := newTestNotificationDispatcher(server, NotificationConfig{
+		Timeout:     time.Second,
+		MaxAttempts: maxAttempts,
+		Backoff:     time.Millisecond,
+	})
+	ctx := context.Background()
+	channel := (&NotificationChannelEntity{}).Build(
+		WithNotificationChannelEntityProvider(NotifierDiscord),
+		WithNotificationChannelEntityURL(server.URL),
+	)
+	data := newTestNotificationData()
+
+	if err := d.deliver(ctx, channel, data, "event"); err == nil {
+		t.Fatal("expected the failed delivery to return an error")
+	}
+	if received := len(server.received()); received != maxAttempts {
+		t.Fatalf("expected %d attempts but got %d", maxAttempts, received)
+	}
+
+	delivery := repo.deliveries[channel.Serial+"/event"]
+	if delivery.Status != NotificationStatusFailed || delivery.Attempts != maxAttempts {
+		t.Errorf("expected a %s delivery after %d attempts but got %s after %d", NotificationStatusFailed, maxAttempts, delivery.Status, delivery.Attempts)
+	}
+
+	server.respond(http.StatusNoContent)
+	if err := d.deliver(ctx, channel, data, "event"); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery = repo.deliveries[channel.Serial+"/event"]
+	if delivery.Status != NotificationStatusDelivered || delivery.Attempts != maxAttempts+1 || delivery.DeliveredAt == nil {
+		t.Errorf("expected a %s delivery after %d attempts but got %s after %d", NotificationStatusDelivered, maxAttempts+1, delivery.Status, delivery.Attempts)
+	}
+
+	if err := d.deliver(ctx, channel, data, "event"); err != nil {
+		t.Fatal(err)
+	}
+	if received := len(server.received()); received != maxAttempts+1 {
+		t.Errorf("expected the delivered notification not to be sent again but got %d requests", received)
+	}
+}
+
+// TestNotificationDisallowedHost notifies a channel stored before the hosts were restricted: it is not posted to,
+// and the failure is tracked without failing the event as no attempt would fix it.
+func TestNotificationDisallowedHost(t *testing.T) {
+	server := newTestWebhookServer(t)
+	d, repo := newTestNotificationDispatcher(server, NotificationConfig{
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	})
+	channel := (&NotificationChannelEntity{}).Build(
+		WithNotificationChannelEntityProvider(NotifierSlack),
+		WithNotificationChannelEntityURL("https://169.254.169.254/latest/meta-data"),
+	)
+
+	if err := d.deliver(context.Background(), channel, newTestNotificationData(), "event"); err != nil {
+		t.Fatal(err)
+	}
+	if received := len(server.received()); received != 0 {
+		t.Errorf("expected no request but got %d", received)
+	}
+	if delivery := repo.deliveries[channel.Serial+"/event"]; delivery.Status != NotificationStatusFailed {
+		t.Errorf("expected a %s delivery but got %s", NotificationStatusFailed, delivery.Status)
+	}
+}
+
+func TestValidateNotificationURL(t *testing.T) {
+	allowedHosts := []string{"discord.com", "hooks.slack.com"}
+
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://discord.com/api/webhooks/1/token", true},
+		{"https://canary.discord.com/api/webhooks/1/token", true},
+		{"https://hooks.slack.com/services/T/B/X", true},
+		{"http://discord.com/api/webhooks/1/token", false},
+		{"https://discord.com.example.com/api/webhooks/1/token", false},
+		{"https://notdiscord.com/api/webhooks/1/token", false},
+		{"https://127.0.0.1/hook", false},
+	}
+
+	for _, test := range tests {
+		err := validateNotificationURL(test.url, allowedHosts)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %v but got %v", test.url, test.valid, err)
+		}
+	}
+}
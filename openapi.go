@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const openAPIVersion = "3.0.3"
+
+// OpenAPIDocument is the OpenAPI 3 description of the REST API, generated from its routes and body types.
+type OpenAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+// NewOpenAPIDocument describes the routes, the body types become schemas named after the Go types.
+func NewOpenAPIDocument(routes []restRoute) *OpenAPIDocument {
+	document := &OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "Donut Call",
+			Version: "v1",
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*openAPISchema),
+		},
+	}
+
+	errorResponse := openAPIResponse{
+		Description: "The error of the request",
+		Content:     jsonContent(document.schemaOf(reflect.TypeOf(restErrorResponse{}))),
+	}
+
+	for _, route := range routes {
+		operation := openAPIOperation{
+			OperationID: route.OperationID,
+			Summary:     route.Summary,
+			Responses: map[string]openAPIResponse{
+				"default": errorResponse,
+			},
+		}
+
+		for _, name := range restPathParameters(route.Path) {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &openAPISchema{Type: "string"},
+			})
+		}
+		for _, query := range route.Query {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name:        query.Name,
+				In:          "query",
+				Description: query.Description,
+				Schema:      &openAPISchema{Type: "string"},
+			})
+		}
+
+		if route.Request != nil {
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content:  jsonContent(document.schemaOf(reflect.TypeOf(route.Request))),
+			}
+		}
+
+		response := openAPIResponse{Description: http.StatusText(route.Status)}
+		if route.Response != nil {
+			response.Content = jsonContent(document.schemaOf(reflect.TypeOf(route.Response)))
+		}
+		operation.Responses[strconv.Itoa(route.Status)] = response
+
+		if document.Paths[route.Path] == nil {
+			document.Paths[route.Path] = make(map[string]openAPIOperation)
+		}
+		document.Paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return document
+}
+
+func jsonContent(schema *openAPISchema) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{
+		"application/json": {Schema: schema},
+	}
+}
+
+// schemaOf returns the schema of the type as encoded by encoding/json, the structs are added to the
+// components and referenced.
+func (d *OpenAPIDocument) schemaOf(t reflect.Type) *openAPISchema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct:
+		return d.structSchema(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	default:
+		return &openAPISchema{Type: "string"}
+	}
+}
+
+// structSchema describes the exported fields of the struct by their JSON name,
+// the fields without omitempty are required.
+func (d *OpenAPIDocument) structSchema(t reflect.Type) *openAPISchema {
+	name := openAPISchemaName(t)
+	ref := &openAPISchema{Ref: "#/components/schemas/" + name}
+	if _, ok := d.Components.Schemas[name]; ok {
+		return ref
+	}
+
+	schema := &openAPISchema{
+		Type:       "object",
+		Properties: make(map[string]*openAPISchema),
+	}
+	// Register the schema before its fields so that a recursive type refers to itself
+	d.Components.Schemas[name] = schema
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		property, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if property == "-" {
+			continue
+		}
+		if property == "" {
+			property = field.Name
+		}
+
+		schema.Properties[property] = d.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, property)
+		}
+	}
+
+	return ref
+}
+
+// openAPISchemaName drops the rest prefix of the unexported body types, restSerialResponse becomes SerialResponse.
+func openAPISchemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "rest")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
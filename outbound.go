@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("address is not public")
+
+// isPublicIP reports whether the address is reachable on the internet rather than the loopback, the private
+// networks, the link local addresses, which include the metadata service of the cloud providers, or multicast.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified())
+}
+
+// validateOutboundURL checks a URL given by a caller to be called by the server later: an absolute http or
+// https URL without credentials whose host, when it is an address or localhost, is public. The hosts named
+// otherwise are checked when they are dialed, see newOutboundHTTPClient.
+func validateOutboundURL(rawURL string, allowPrivate bool) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return NewValidationError("url", "must be an absolute http or https url")
+	}
+
+	if parsed.User != nil {
+		return NewValidationError("url", "must not hold credentials")
+	}
+
+	if allowPrivate {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return NewValidationError("url", fmt.Sprintf("host %s: %s", host, ErrPrivateAddress))
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return NewValidationError("url", fmt.Sprintf("host %s: %s", host, ErrPrivateAddress))
+	}
+
+	return nil
+}
+
+// newOutboundHTTPClient returns the client of the requests sent to the URLs given by callers. Unless private
+// addresses are allowed it refuses to connect to them, the check is made on the address actually dialed so a
+// name resolving to one, or a redirect to one, is refused too. The environment proxy is not used as it would
+// connect on the client's behalf.
+func newOutboundHTTPClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("dial %s: %w", address, ErrPrivateAddress)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
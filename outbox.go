@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// maxOutboxBackoff bounds the wait between the relays of a failed event.
+const maxOutboxBackoff = time.Hour
+
+const (
+	DeliveredAtColumn    = "delivered_at"
+	DeadLetteredAtColumn = "dead_lettered_at"
+	AttemptsColumn       = "attempts"
+)
+
+type OutboxConfig struct {
+	Interval  time.Duration `env:"OUTBOX_INTERVAL" envDefault:"5s"`
+	BatchSize int           `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	// A failed event is relayed again after the backoff, doubled on every attempt up to an hour, and dead lettered
+	// after MaxAttempts: it stays in the outbox but is not relayed again
+	Backoff     time.Duration `env:"OUTBOX_BACKOFF" envDefault:"5s"`
+	MaxAttempts int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	Sinks       []string      `env:"OUTBOX_SINKS" envDefault:"log,webhook" envSeparator:"," enum:"log,webhook,redis,notify"`
+	Stream      string        `env:"OUTBOX_STREAM" envDefault:"donut.events"`
+}
+
+// OutboxEvent is an event waiting to be relayed to the sinks. A dead lettered event failed every attempt,
+// it is relayed again once DeadLetteredAt is cleared.
+type OutboxEvent struct {
+	ID               uint64 `gorm:"primaryKey;autoIncrement"`
+	Serial           string `gorm:"uniqueIndex;size:36"`
+	MatchMakerSerial string `gorm:"column:matchmaker_serial;index;size:36"`
+	Type             EventType
+	Payload          string `gorm:"type:text"`
+	Attempts         int
+	Error            string `gorm:"type:text"`
+	NextAttemptAt    *time.Time
+	DeliveredAt      *time.Time `gorm:"index"`
+	DeadLetteredAt   *time.Time `gorm:"index"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox_event"
+}
+
+func (OutboxEvent) FromEntity(entity *EventEntity) *OutboxEvent {
+	if entity == nil {
+		return nil
+	}
+
+	return &OutboxEvent{
+		Serial:           entity.Serial,
+		MatchMakerSerial: entity.MatchMakerSerial,
+		Type:             entity.Type,
+		Payload:          string(entity.Payload),
+		CreatedAt:        entity.CreatedAt,
+	}
+}
+
+func (o *OutboxEvent) ToEntity() *EventEntity {
+	if o == nil {
+		return nil
+	}
+
+	return &EventEntity{
+		ID:               o.ID,
+		Serial:           o.Serial,
+		MatchMakerSerial: o.MatchMakerSerial,
+		Type:             o.Type,
+		Payload:          []byte(o.Payload),
+		Attempts:         o.Attempts,
+		NextAttemptAt:    o.NextAttemptAt,
+		CreatedAt:        o.CreatedAt,
+	}
+}
+
+type OutboxEvents []*OutboxEvent
+
+func (o OutboxEvents) ToEntities() EventEntities {
+	var entities EventEntities
+	for _, event := range o {
+		if event == nil {
+			continue
+		}
+		entities = append(entities, event.ToEntity())
+	}
+	return entities
+}
+
+// OutboxEventFilter pages the undelivered events after the event AfterID, skipping the match makers whose
+// events must wait.
+type OutboxEventFilter struct {
+	AfterID                   uint64
+	ExcludedMatchMakerSerials []string
+	Limit                     int
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+type OutboxRepository interface {
+	CreateEvent(ctx context.Context, event *EventEntity) error
+	GetUndeliveredEvents(ctx context.Context, filter OutboxEventFilter) (EventEntities, error)
+	MarkEventDelivered(ctx context.Context, serial string) error
+	RetryEventLater(ctx context.Context, serial, reason string, nextAttemptAt time.Time) error
+	DeadLetterEvent(ctx context.Context, serial, reason string) error
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{
+		db: db,
+	}
+}
+
+// CreateEvent appends the event to the outbox, inside the transaction of the context when there is one.
+func (r *outboxRepository) CreateEvent(ctx context.Context, event *EventEntity) error {
+	return transactionOrDB(ctx, r.db).Create(OutboxEvent{}.FromEntity(event)).Error
+}
+
+// GetUndeliveredEvents returns the events neither delivered nor dead lettered, in the order they were stored.
+func (r *outboxRepository) GetUndeliveredEvents(ctx context.Context, filter OutboxEventFilter) (EventEntities, error) {
+	q := fmt.Sprintf("%s IS NULL AND %s IS NULL AND %s > ?", DeliveredAtColumn, DeadLetteredAtColumn, IDColumn)
+	query := transactionOrDB(ctx, r.db).Where(q, filter.AfterID)
+
+	if len(filter.ExcludedMatchMakerSerials) > 0 {
+		query = query.Where(fmt.Sprintf("%s NOT IN ?", MatchMakerSerialColumn), filter.ExcludedMatchMakerSerials)
+	}
+
+	var events OutboxEvents
+	err := query.Order(IDColumn).Limit(filter.Limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events.ToEntities(), nil
+}
+
+func (r *outboxRepository) MarkEventDelivered(ctx context.Context, serial string) error {
+	q := fmt.Sprintf("%s = ?", SerialColumn)
+	return transactionOrDB(ctx, r.db).
+		Model(&OutboxEvent{}).
+		Where(q, serial).
+		Update(DeliveredAtColumn, time.Now()).
+		Error
+}
+
+// RetryEventLater counts a failed relay of the event, which is not relayed again before nextAttemptAt.
+func (r *outboxRepository) RetryEventLater(ctx context.Context, serial, reason string, nextAttemptAt time.Time) error {
+	return r.recordEventFailure(ctx, serial, map[string]interface{}{
+		ErrorColumn:         reason,
+		NextAttemptAtColumn: nextAttemptAt,
+	})
+}
+
+// DeadLetterEvent counts the last failed relay of the event, which is not relayed again.
+func (r *outboxRepository) DeadLetterEvent(ctx context.Context, serial, reason string) error {
+	return r.recordEventFailure(ctx, serial, map[string]interface{}{
+		ErrorColumn:          reason,
+		DeadLetteredAtColumn: time.Now(),
+	})
+}
+
+func (r *outboxRepository) recordEventFailure(ctx context.Context, serial string, updates map[string]interface{}) error {
+	updates[AttemptsColumn] = gorm.Expr(fmt.Sprintf("%s + 1", AttemptsColumn))
+
+	q := fmt.Sprintf("%s = ?", SerialColumn)
+	return transactionOrDB(ctx, r.db).
+		Model(&OutboxEvent{}).
+		Where(q, serial).
+		Updates(updates).
+		Error
+}
+
+// OutboxRelay polls the outbox and hands every undelivered event to the sinks, giving at-least-once delivery.
+// An event is marked as delivered only after every sink accepted it, so a crash or a failing sink delivers it again
+// on the next run. Events of a match maker are relayed in the order they were stored, once an event fails the
+// following events of the same match maker wait, until the backoff of the failed event is over, while other match
+// makers keep going. An event failing MaxAttempts times is dead lettered so that it doesn't hold its match maker
+// back forever.
+type OutboxRelay struct {
+	outbox OutboxRepository
+	sinks  []EventSink
+	cfg    OutboxConfig
+}
+
+func NewOutboxRelay(outbox OutboxRepository, sinks []EventSink, cfg OutboxConfig) *OutboxRelay {
+	return &OutboxRelay{
+		outbox: outbox,
+		sinks:  sinks,
+		cfg:    cfg,
+	}
+}
+
+// Run relays the outbox every interval until the context is done.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Relay(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to relay outbox events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay goes through the undelivered events a batch at a time, the match makers blocked by a failed event are
+// left out of the following batches so that their waiting events don't crowd out the others.
+func (r *OutboxRelay) Relay(ctx context.Context) error {
+	filter := OutboxEventFilter{Limit: r.cfg.BatchSize}
+	blocked := make(map[string]struct{})
+	block := func(matchMakerSerial string) {
+		blocked[matchMakerSerial] = struct{}{}
+		filter.ExcludedMatchMakerSerials = append(filter.ExcludedMatchMakerSerials, matchMakerSerial)
+	}
+
+	for {
+		events, err := r.outbox.GetUndeliveredEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if event == nil {
+				continue
+			}
+			filter.AfterID = event.ID
+
+			if _, ok := blocked[event.MatchMakerSerial]; ok {
+				continue
+			}
+
+			if event.NextAttemptAt != nil && time.Now().Before(*event.NextAttemptAt) {
+				block(event.MatchMakerSerial)
+				continue
+			}
+
+			if err := r.publish(ctx, event); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				if err := r.fail(ctx, event, err); err != nil {
+					return err
+				}
+				if event.Attempts+1 < r.cfg.MaxAttempts {
+					block(event.MatchMakerSerial)
+				}
+				continue
+			}
+
+			if err := r.outbox.MarkEventDelivered(ctx, event.Serial); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < filter.Limit {
+			return nil
+		}
+	}
+}
+
+// fail records the failed relay of the event, to retry after its backoff or as a dead letter after the last attempt.
+func (r *OutboxRelay) fail(ctx context.Context, event *EventEntity, reason error) error {
+	attempts := event.Attempts + 1
+	logger := log.With().Err(reason).Str("event", event.Serial).Int("attempts", attempts).Logger()
+
+	if attempts >= r.cfg.MaxAttempts {
+		logger.Error().Msg("outbox event failed every attempt, moving to dead letter")
+		return r.outbox.DeadLetterEvent(ctx, event.Serial, reason.Error())
+	}
+
+	backoff := maxOutboxBackoff
+	if shift := attempts - 1; shift < 32 && r.cfg.Backoff<<shift < maxOutboxBackoff {
+		backoff = r.cfg.Backoff << shift
+	}
+
+	logger.Warn().Dur("backoff", backoff).Msg("failed to publish outbox event")
+	return r.outbox.RetryEventLater(ctx, event.Serial, reason.Error(), time.Now().Add(backoff))
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, event *EventEntity) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			return fmt.Errorf("%s sink: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"time"
 
 	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
@@ -8,19 +9,58 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// NewCreateMatchMakerRequest builds the request of CreateMatchMaker for the entry points taking the match maker
+// field by field, such as the imports, so that ValidateRequest checks them like the RPC.
+func NewCreateMatchMakerRequest(name, description string, startTime *time.Time, days int32) *donutv1.CreateMatchMakerRequest {
+	msg := &donutv1.CreateMatchMakerRequest{
+		MatchMaker: &donutv1.MatchMaker{
+			Name:        strings.TrimSpace(name),
+			Description: description,
+			Duration:    days,
+		},
+	}
+	if startTime != nil {
+		msg.MatchMaker.StartTime = timestamppb.New(*startTime)
+	}
+	return msg
+}
+
+// validateImportedPeople applies the rules of RegisterPeople to every person of an import.
+func validateImportedPeople(matchMakerSerial string, people MatchMakerUserEntities, cfg ValidationConfig) error {
+	for _, person := range people {
+		if person == nil {
+			continue
+		}
+		err := ValidateRequest(&donutv1.RegisterPeopleRequest{
+			MatchmakerSerial: matchMakerSerial,
+			Reference:        person.UserReference,
+		}, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func parseCreateMatchMakerRequest(req *connect.Request[donutv1.CreateMatchMakerRequest]) *MatchMakerEntity {
 	if req.Msg.MatchMaker == nil {
 		return nil
 	}
 
-	matchMakerEntity := &MatchMakerEntity{}
-
-	return matchMakerEntity.Build(
+	options := []MatchMakerEntityOption{
 		WithMatchMakerEntityName(req.Msg.MatchMaker.GetName()),
 		WithMatchMakerEntityDescription(req.Msg.MatchMaker.GetDescription()),
-		WithMatchMakerEntityStartTime(req.Msg.MatchMaker.GetStartTime().AsTime()),
 		WithMatchMakerEntityDuration(time.Duration(req.Msg.MatchMaker.GetDuration())),
-	)
+	}
+
+	// A missing start time defaults to now instead of the epoch
+	if req.Msg.MatchMaker.GetStartTime() != nil {
+		options = append(options, WithMatchMakerEntityStartTime(req.Msg.MatchMaker.GetStartTime().AsTime()))
+	}
+
+	matchMakerEntity := &MatchMakerEntity{}
+
+	return matchMakerEntity.Build(options...)
 }
 
 func parseCreateMatchMakerResponse(serial string) *connect.Response[donutv1.CreateMatchMakerResponse] {
@@ -39,7 +79,7 @@ func parseGetMatchMakerInformationResponse(info *MatchMakerInformation) *connect
 				Name:        info.MatchMaker.Name,
 				Description: info.MatchMaker.Description,
 				StartTime:   timestamppb.New(info.MatchMaker.StartTime),
-				Duration:    int32(info.MatchMaker.Duration),
+				Duration:    int32(info.MatchMaker.Duration / Day),
 			},
 		},
 	)
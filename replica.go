@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	trmgorm "github.com/avito-tech/go-transaction-manager/drivers/gorm/v2"
+	"gorm.io/gorm"
+)
+
+type replicaReadsKey struct{}
+type writeTrackerKey struct{}
+
+// WithReplicaReads lets the reads of a read only call go to a replica, which may lag behind the primary.
+func WithReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, true)
+}
+
+// withoutReplicaReads sends the reads of the call to the primary again, even in a read only call.
+func withoutReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, false)
+}
+
+func replicaReadsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(replicaReadsKey{}).(bool)
+	return allowed
+}
+
+// WithWriteTracking remembers the writes made with the context, so that the reads following a write
+// in the same request go to the primary and see it.
+func WithWriteTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeTrackerKey{}, new(atomic.Bool))
+}
+
+func markWritten(ctx context.Context) {
+	if written, ok := ctx.Value(writeTrackerKey{}).(*atomic.Bool); ok {
+		written.Store(true)
+	}
+}
+
+func hasWritten(ctx context.Context) bool {
+	written, ok := ctx.Value(writeTrackerKey{}).(*atomic.Bool)
+	return ok && written.Load()
+}
+
+func inTransaction(ctx context.Context) bool {
+	return trmgorm.DefaultCtxGetter.DefaultTrOrDB(ctx, nil) != nil
+}
+
+// replicaSet spreads the reads over the replicas in turn.
+type replicaSet struct {
+	dbs  []*gorm.DB
+	next atomic.Uint64
+}
+
+func newReplicaSet(dbs []*gorm.DB) *replicaSet {
+	return &replicaSet{
+		dbs: dbs,
+	}
+}
+
+func (s *replicaSet) pick() *gorm.DB {
+	if len(s.dbs) == 0 {
+		return nil
+	}
+	return s.dbs[(s.next.Add(1)-1)%uint64(len(s.dbs))]
+}
@@ -3,38 +3,52 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	trmgorm "github.com/avito-tech/go-transaction-manager/drivers/gorm/v2"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 type donutRepository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	replicas *replicaSet
 }
 
 type DonutRepository interface {
 	CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) error
 	CreateMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error
 	UpdateMatchMakerStatusBySerial(ctx context.Context, serial string, status MatchMakerStatus) error
+	SwapMatchMakerStatusBySerial(ctx context.Context, serial string, from, to MatchMakerStatus) (bool, error)
 
 	UpdateSerialMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error
 	UpdateSerialMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error
 	UpdateStatusMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error
 	UpdateStatusMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error
 	DeleteMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error
+	RestoreMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error)
+	ReregisterMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error)
+	PurgeDeletedMatchMakerUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
+	ArchiveMatchMaker(ctx context.Context, serial string) error
 
 	GetMatchMakerBySerial(ctx context.Context, serial string) (*MatchMakerEntity, error)
+	GetMatchMakersByStatuses(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error)
 	GetUsersByMatchMakerSerial(ctx context.Context, matchMakerSerial string) (MatchMakerUserEntities, error)
 	GetUsersByMatchMakerSerialAndStatuses(ctx context.Context, matchMakerSerial string, status []MatchMakerUserStatus) (MatchMakerUserEntities, error)
 	GetUsersByMatchMakerSerialAndUserReferences(ctx context.Context, matchMakerSerial string, userReferences []string) (MatchMakerUserEntities, error)
 	GetUsersBySerial(ctx context.Context, serial string) (MatchMakerUserEntities, error)
+	GetMatchMakerSerialsByStatusesUpdatedBefore(ctx context.Context, statuses []MatchMakerStatus, updatedBefore time.Time, limit int) ([]string, error)
+	CountMatchMakersByStatus(ctx context.Context) (map[MatchMakerStatus]int64, error)
 
 	Database() *gorm.DB
 }
 
-func NewDonutRepository(db *gorm.DB) DonutRepository {
+// NewDonutRepository writes to the primary db and reads from the replicas when there are any, see reader.
+func NewDonutRepository(db *gorm.DB, replicas ...*gorm.DB) DonutRepository {
 	return &donutRepository{
-		db: db,
+		db:       db,
+		replicas: newReplicaSet(replicas),
 	}
 }
 
@@ -42,58 +56,72 @@ func (r *donutRepository) Database() *gorm.DB {
 	return r.db
 }
 
+// transactionOrDB returns the transaction stored in the context by the transaction manager,
+// or the plain database connection when the call is not part of a transaction.
+func transactionOrDB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return trmgorm.DefaultCtxGetter.DefaultTrOrDB(ctx, db).WithContext(ctx)
+}
+
+// reader returns the database of a read. It is a replica only when the call allows replica reads and neither
+// a transaction nor an earlier write of the same request needs the primary.
+func (r *donutRepository) reader(ctx context.Context) *gorm.DB {
+	if !replicaReadsAllowed(ctx) || hasWritten(ctx) || inTransaction(ctx) {
+		return transactionOrDB(ctx, r.db)
+	}
+
+	replica := r.replicas.pick()
+	if replica == nil {
+		return transactionOrDB(ctx, r.db)
+	}
+	return replica.WithContext(ctx)
+}
+
+// writer returns the primary database, or the transaction of the context, and marks the request as written.
+func (r *donutRepository) writer(ctx context.Context) *gorm.DB {
+	markWritten(ctx)
+	return transactionOrDB(ctx, r.db)
+}
+
 func (r *donutRepository) CreateMatchMaker(ctx context.Context, matchMaker *MatchMakerEntity) error {
-	return r.db.WithContext(ctx).Create(MatchMaker{}.FromEntity(matchMaker)).Error
+	return r.writer(ctx).Create(MatchMaker{}.FromEntity(matchMaker)).Error
 }
 
 func (r *donutRepository) CreateMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
 	clauses := clause.OnConflict{DoNothing: true}
-	return r.db.
-		WithContext(ctx).
+	return r.writer(ctx).
 		Clauses(clauses).
 		Model(&MatchMakerUser{}).
 		Create(MatchMakerUsers{}.FromEntities(matchMakerUsers)).
 		Error
 }
 
-// UpdateStatusMatchMakerUsers updates only for status of match maker users.
-// This implementation is to handle for multiple update queries and transaction by gorm.
-// The manager pattern is not necessary for this implementation.
-func (r *donutRepository) UpdateStatusMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (err error) {
-	trx := r.db.WithContext(ctx).Begin()
-
-	defer func() {
-		if err != nil {
-			err = trx.Rollback().Error
-			return
-		}
-
-		err = trx.Commit().Error
-		return
-	}()
-
-	for _, matchMakerUser := range matchMakerUsers {
-		if matchMakerUser == nil {
-			continue
-		}
-		q := fmt.Sprintf("%s = ?", SerialColumn)
-		err := trx.Model(&MatchMakerUser{}).
-			Where(q, matchMakerUser.Serial).
-			Update(StatusColumn, matchMakerUser.Status).
-			Error
-		if err != nil {
-			return err
+// UpdateStatusMatchMakerUsers updates the status of match maker users with one statement per match maker
+// and status, matching the users by their reference.
+func (r *donutRepository) UpdateStatusMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	q := fmt.Sprintf("%s = ? AND %s IN ?", MatchMakerSerialColumn, UserReferenceColumn)
+	return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, batch := range batchMatchMakerUsers(matchMakerUsers, func(matchMakerUser *MatchMakerUserEntity) string {
+			return matchMakerUser.MatchMakerSerial + "\x00" + string(matchMakerUser.Status)
+		}) {
+			first := batch[0]
+			err := tx.Model(&MatchMakerUser{}).
+				Where(q, first.MatchMakerSerial, batch.ToPeople().ToUserReferences()).
+				Update(StatusColumn, first.Status).
+				Error
+			if err != nil {
+				return err
+			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
+// DeleteMatchMakerUsers soft deletes match maker users, they are hidden from every query but kept for history.
 func (r *donutRepository) DeleteMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
 	q := fmt.Sprintf("%s = ? AND %s = ?", MatchMakerSerialColumn, UserReferenceColumn)
-	return r.db.Transaction(func(tx *gorm.DB) error {
+	return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
 		for _, matchMakerUser := range matchMakerUsers {
-			err := tx.WithContext(ctx).
+			err := tx.
 				Where(q, matchMakerUser.MatchMakerSerial, matchMakerUser.UserReference).
 				Delete(MatchMakerUsers{}).
 				Error
@@ -105,20 +133,119 @@ func (r *donutRepository) DeleteMatchMakerUsers(ctx context.Context, matchMakerU
 	})
 }
 
+// RestoreMatchMakerUsers undoes the soft delete of match maker users and returns how many were restored.
+func (r *donutRepository) RestoreMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error) {
+	return r.restoreMatchMakerUsers(ctx, matchMakerUsers, map[string]interface{}{
+		DeletedAtColumn: nil,
+	})
+}
+
+// ReregisterMatchMakerUsers undoes the soft delete of match maker users registered again, as new registrations:
+// pending and out of the pair they had. It returns how many were registered again.
+func (r *donutRepository) ReregisterMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (int64, error) {
+	return r.restoreMatchMakerUsers(ctx, matchMakerUsers, map[string]interface{}{
+		DeletedAtColumn: nil,
+		StatusColumn:    MatchMakerUserStatusPending,
+		SerialColumn:    "",
+	})
+}
+
+func (r *donutRepository) restoreMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities, updates map[string]interface{}) (int64, error) {
+	var restored int64
+	q := fmt.Sprintf("%s = ? AND %s IN ? AND %s IS NOT NULL", MatchMakerSerialColumn, UserReferenceColumn, DeletedAtColumn)
+	err := r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, batch := range batchMatchMakerUsers(matchMakerUsers, func(matchMakerUser *MatchMakerUserEntity) string {
+			return matchMakerUser.MatchMakerSerial
+		}) {
+			result := tx.Unscoped().
+				Model(&MatchMakerUser{}).
+				Where(q, batch[0].MatchMakerSerial, batch.ToPeople().ToUserReferences()).
+				Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			restored += result.RowsAffected
+		}
+		return nil
+	})
+	return restored, err
+}
+
+// PurgeDeletedMatchMakerUsers permanently deletes the match maker users soft deleted before the given time.
+func (r *donutRepository) PurgeDeletedMatchMakerUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	q := fmt.Sprintf("%s < ?", DeletedAtColumn)
+	result := r.writer(ctx).Unscoped().Where(q, deletedBefore).Delete(&MatchMakerUser{})
+	return result.RowsAffected, result.Error
+}
+
+// ArchiveMatchMaker moves the match maker and all of its users, unregistered ones included, to the archive tables.
+func (r *donutRepository) ArchiveMatchMaker(ctx context.Context, serial string) error {
+	return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+		var matchMaker MatchMaker
+		err := tx.Where(fmt.Sprintf("%s = ?", SerialColumn), serial).First(&matchMaker).Error
+		if err != nil {
+			return err
+		}
+
+		var matchMakerUsers MatchMakerUsers
+		q := fmt.Sprintf("%s = ?", MatchMakerSerialColumn)
+		err = tx.Unscoped().Where(q, serial).Find(&matchMakerUsers).Error
+		if err != nil {
+			return err
+		}
+
+		archivedAt := time.Now()
+		err = tx.Create(MatchMakerArchive{}.FromMatchMaker(&matchMaker, archivedAt)).Error
+		if err != nil {
+			return err
+		}
+
+		if len(matchMakerUsers) > 0 {
+			archives := MatchMakerUserArchives{}.FromMatchMakerUsers(matchMakerUsers, archivedAt)
+			err = tx.CreateInBatches(archives, matchMakerUserBatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Unscoped().Where(q, serial).Delete(&MatchMakerUser{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where(fmt.Sprintf("%s = ?", SerialColumn), serial).Delete(&MatchMaker{}).Error
+	})
+}
+
 func (r *donutRepository) GetMatchMakerBySerial(ctx context.Context, serial string) (*MatchMakerEntity, error) {
 	var matchMaker MatchMaker
 	q := fmt.Sprintf("%s = ?", SerialColumn)
-	err := r.db.WithContext(ctx).Where(q, serial).First(&matchMaker).Error
+	err := r.reader(ctx).Where(q, serial).First(&matchMaker).Error
 	if err != nil {
 		return nil, err
 	}
 	return matchMaker.ToEntity(), nil
 }
 
+// GetMatchMakersByStatuses returns the match makers with one of the statuses, or all of them without statuses.
+func (r *donutRepository) GetMatchMakersByStatuses(ctx context.Context, statuses []MatchMakerStatus) (MatchMakerEntities, error) {
+	query := r.reader(ctx)
+	if len(statuses) > 0 {
+		query = query.Where(fmt.Sprintf("%s IN ?", StatusColumn), statuses)
+	}
+
+	var matchMakers MatchMakers
+	err := query.Order(fmt.Sprintf("%s DESC", StartTimeColumn)).Find(&matchMakers).Error
+	if err != nil {
+		return nil, err
+	}
+	return matchMakers.ToEntities(), nil
+}
+
 func (r *donutRepository) GetUsersByMatchMakerSerial(ctx context.Context, matchMakerSerial string) (MatchMakerUserEntities, error) {
 	var matchMakerUsers MatchMakerUsers
 	q := fmt.Sprintf("%s = ?", MatchMakerSerialColumn)
-	err := r.db.WithContext(ctx).Where(q, matchMakerSerial).Find(&matchMakerUsers).Error
+	err := r.reader(ctx).Where(q, matchMakerSerial).Find(&matchMakerUsers).Error
 	if err != nil {
 		return nil, err
 	}
@@ -128,49 +255,38 @@ func (r *donutRepository) GetUsersByMatchMakerSerial(ctx context.Context, matchM
 func (r *donutRepository) GetUsersByMatchMakerSerialAndStatuses(ctx context.Context, matchMakerSerial string, status []MatchMakerUserStatus) (MatchMakerUserEntities, error) {
 	var matchMakerUsers MatchMakerUsers
 	q := fmt.Sprintf("%s = ? AND %s IN (?)", MatchMakerSerialColumn, StatusColumn)
-	err := r.db.WithContext(ctx).Where(q, matchMakerSerial, status).Find(&matchMakerUsers).Error
+	err := r.reader(ctx).Where(q, matchMakerSerial, status).Find(&matchMakerUsers).Error
 	if err != nil {
 		return nil, err
 	}
 	return matchMakerUsers.ToEntities(), nil
 }
 
-// UpdateSerialMatchMakerUsers updates only for serial and status of match maker users.
-// This implementation is to handle for multiple update queries and transaction by gorm.
-// The manager pattern is not necessary for this implementation.
-func (r *donutRepository) UpdateSerialMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) (err error) {
-	trx := r.db.WithContext(ctx).Begin()
-
-	defer func() {
-		if err != nil {
-			err = trx.Rollback().Error
-			return
-		}
-
-		err = trx.Commit().Error
-		return
-	}()
-
-	for _, matchMakerUser := range matchMakerUsers {
-		if matchMakerUser == nil {
-			continue
-		}
-		q := fmt.Sprintf("%s = ? AND %s = ?", MatchMakerSerialColumn, UserReferenceColumn)
-		updates := map[string]interface{}{
-			SerialColumn: matchMakerUser.Serial,
-			StatusColumn: MatchMakerUserStatusRunning,
-		}
+// UpdateSerialMatchMakerUsers sets the pair serial and the running status of match maker users
+// with one CASE based statement per match maker, instead of one statement per user.
+func (r *donutRepository) UpdateSerialMatchMakerUsers(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+	q := fmt.Sprintf("%s = ? AND %s IN ?", MatchMakerSerialColumn, UserReferenceColumn)
+	return r.writer(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, batch := range batchMatchMakerUsers(matchMakerUsers, func(matchMakerUser *MatchMakerUserEntity) string {
+			return matchMakerUser.MatchMakerSerial
+		}) {
+			updates := map[string]interface{}{
+				SerialColumn: caseByUserReference(batch, func(matchMakerUser *MatchMakerUserEntity) interface{} {
+					return matchMakerUser.Serial
+				}),
+				StatusColumn: MatchMakerUserStatusRunning,
+			}
 
-		err := trx.Model(&MatchMakerUser{}).
-			Where(q, matchMakerUser.MatchMakerSerial, matchMakerUser.UserReference).
-			Updates(updates).
-			Error
-		if err != nil {
-			return err
+			err := tx.Model(&MatchMakerUser{}).
+				Where(q, batch[0].MatchMakerSerial, batch.ToPeople().ToUserReferences()).
+				Updates(updates).
+				Error
+			if err != nil {
+				return err
+			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func (r *donutRepository) UpdateSerialMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error {
@@ -179,7 +295,7 @@ func (r *donutRepository) UpdateSerialMatchMakerUser(ctx context.Context, matchM
 		SerialColumn: matchMakerUser.Serial,
 		StatusColumn: MatchMakerUserStatusRunning,
 	}
-	return r.db.WithContext(ctx).
+	return r.writer(ctx).
 		Model(&MatchMakerUser{}).
 		Where(q, matchMakerUser.MatchMakerSerial, matchMakerUser.UserReference).
 		Updates(updates).
@@ -197,7 +313,7 @@ func (r *donutRepository) GetUsersByMatchMakerSerialAndUserReferences(ctx contex
 		}
 		var batchMatchMakerUsers MatchMakerUsers
 		q := fmt.Sprintf("%s = ? AND %s IN ?", MatchMakerSerialColumn, UserReferenceColumn)
-		err := r.db.WithContext(ctx).Where(q, matchMakerSerial, userReferences[i:end]).Find(&batchMatchMakerUsers).Error
+		err := r.reader(ctx).Where(q, matchMakerSerial, userReferences[i:end]).Find(&batchMatchMakerUsers).Error
 		if err != nil {
 			return nil, err
 		}
@@ -210,7 +326,7 @@ func (r *donutRepository) GetUsersByMatchMakerSerialAndUserReferences(ctx contex
 func (r *donutRepository) GetUsersBySerial(ctx context.Context, serial string) (MatchMakerUserEntities, error) {
 	var matchMakerUsers MatchMakerUsers
 	q := fmt.Sprintf("%s = ?", SerialColumn)
-	err := r.db.WithContext(ctx).Where(q, serial).Find(&matchMakerUsers).Error
+	err := r.reader(ctx).Where(q, serial).Find(&matchMakerUsers).Error
 	if err != nil {
 		return nil, err
 	}
@@ -219,21 +335,124 @@ func (r *donutRepository) GetUsersBySerial(ctx context.Context, serial string) (
 
 func (r *donutRepository) UpdateMatchMakerStatusBySerial(ctx context.Context, serial string, status MatchMakerStatus) error {
 	q := fmt.Sprintf("%s = ?", SerialColumn)
-	return r.db.WithContext(ctx).
+	return r.writer(ctx).
 		Model(&MatchMaker{}).
 		Where(q, serial).
 		Update(StatusColumn, status).
 		Error
 }
 
+// SwapMatchMakerStatusBySerial changes the status only when it is still the expected one and reports whether it did.
+// Concurrent swaps of the same match maker are serialized by the row lock of the UPDATE, so exactly one of them wins.
+func (r *donutRepository) SwapMatchMakerStatusBySerial(ctx context.Context, serial string, from, to MatchMakerStatus) (bool, error) {
+	q := fmt.Sprintf("%s = ? AND %s = ?", SerialColumn, StatusColumn)
+	result := r.writer(ctx).
+		Model(&MatchMaker{}).
+		Where(q, serial, from).
+		Update(StatusColumn, to)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
 func (r *donutRepository) UpdateStatusMatchMakerUser(ctx context.Context, matchMakerUser *MatchMakerUserEntity) error {
 	q := fmt.Sprintf("%s = ? AND %s = ?", MatchMakerSerialColumn, UserReferenceColumn)
 	updates := map[string]interface{}{
 		StatusColumn: matchMakerUser.Status,
 	}
-	return r.db.WithContext(ctx).
+	return r.writer(ctx).
 		Model(&MatchMakerUser{}).
 		Where(q, matchMakerUser.MatchMakerSerial, matchMakerUser.UserReference).
 		Updates(updates).
 		Error
 }
+
+func (r *donutRepository) GetMatchMakerSerialsByStatusesUpdatedBefore(ctx context.Context, statuses []MatchMakerStatus, updatedBefore time.Time, limit int) ([]string, error) {
+	var serials []string
+	q := fmt.Sprintf("%s IN ? AND %s < ?", StatusColumn, UpdatedAtColumn)
+	err := r.reader(ctx).
+		Model(&MatchMaker{}).
+		Where(q, statuses, updatedBefore).
+		Order(UpdatedAtColumn).
+		Limit(limit).
+		Pluck(SerialColumn, &serials).
+		Error
+	return serials, err
+}
+
+func (r *donutRepository) CountMatchMakersByStatus(ctx context.Context) (map[MatchMakerStatus]int64, error) {
+	var rows []struct {
+		Status MatchMakerStatus
+		Count  int64
+	}
+
+	err := r.reader(ctx).
+		Model(&MatchMaker{}).
+		Select(fmt.Sprintf("%s, COUNT(*) AS count", StatusColumn)).
+		Group(StatusColumn).
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[MatchMakerStatus]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// matchMakerUserBatchSize bounds the users of a single bulk statement,
+// keeping its placeholders far below the limits of MySQL and Postgres.
+const matchMakerUserBatchSize = 500
+
+// batchMatchMakerUsers groups the users by key, in order of first appearance, and splits every group
+// into batches of at most matchMakerUserBatchSize users.
+func batchMatchMakerUsers(matchMakerUsers MatchMakerUserEntities, key func(*MatchMakerUserEntity) string) []MatchMakerUserEntities {
+	var keys []string
+	groups := make(map[string]MatchMakerUserEntities)
+
+	for _, matchMakerUser := range matchMakerUsers {
+		if matchMakerUser == nil {
+			continue
+		}
+		k := key(matchMakerUser)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], matchMakerUser)
+	}
+
+	var batches []MatchMakerUserEntities
+	for _, k := range keys {
+		group := groups[k]
+		for i := 0; i < len(group); i += matchMakerUserBatchSize {
+			end := i + matchMakerUserBatchSize
+			if end > len(group) {
+				end = len(group)
+			}
+			batches = append(batches, group[i:end])
+		}
+	}
+
+	return batches
+}
+
+// caseByUserReference builds "CASE user_reference WHEN ? THEN ? ... END" so that a single UPDATE
+// can set a different value for every user.
+func caseByUserReference(matchMakerUsers MatchMakerUserEntities, value func(*MatchMakerUserEntity) interface{}) clause.Expr {
+	var sql strings.Builder
+	vars := make([]interface{}, 0, len(matchMakerUsers)*2)
+
+	sql.WriteString("CASE ")
+	sql.WriteString(UserReferenceColumn)
+	for _, matchMakerUser := range matchMakerUsers {
+		sql.WriteString(" WHEN ? THEN ?")
+		vars = append(vars, matchMakerUser.UserReference, value(matchMakerUser))
+	}
+	sql.WriteString(" END")
+
+	return gorm.Expr(sql.String(), vars...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// benchmarkParticipants is the size of a large guild, the batched updates are compared with the per user ones.
+const benchmarkParticipants = 10000
+
+func BenchmarkUpdateSerialMatchMakerUsers(b *testing.B) {
+	repo := NewDonutRepository(openTestDatabase(b))
+
+	b.Run("batch", func(b *testing.B) {
+		benchmarkUpdateSerial(b, repo, repo.UpdateSerialMatchMakerUsers)
+	})
+	b.Run("per user", func(b *testing.B) {
+		benchmarkUpdateSerial(b, repo, func(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+			for _, matchMakerUser := range matchMakerUsers {
+				if err := repo.UpdateSerialMatchMakerUser(ctx, matchMakerUser); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func BenchmarkUpdateStatusMatchMakerUsers(b *testing.B) {
+	repo := NewDonutRepository(openTestDatabase(b))
+
+	b.Run("batch", func(b *testing.B) {
+		benchmarkUpdateStatus(b, repo, repo.UpdateStatusMatchMakerUsers)
+	})
+	b.Run("per user", func(b *testing.B) {
+		benchmarkUpdateStatus(b, repo, func(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error {
+			for _, matchMakerUser := range matchMakerUsers {
+				if err := repo.UpdateStatusMatchMakerUser(ctx, matchMakerUser); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// benchmarkUpdateSerial pairs the people two by two, as Pair does, and times the update of their serials.
+func benchmarkUpdateSerial(b *testing.B, repo DonutRepository, update func(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error) {
+	ctx := context.Background()
+	_, matchMakerUsers := createTestMatchMaker(b, repo, benchmarkParticipants)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j, matchMakerUser := range matchMakerUsers {
+			if j%2 == 0 {
+				matchMakerUser.Serial = GenerateSerial()
+			} else {
+				matchMakerUser.Serial = matchMakerUsers[j-1].Serial
+			}
+		}
+		b.StartTimer()
+
+		if err := update(ctx, matchMakerUsers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkUpdateStatus times the update of the statuses, alternating them so that every run changes the rows.
+func benchmarkUpdateStatus(b *testing.B, repo DonutRepository, update func(ctx context.Context, matchMakerUsers MatchMakerUserEntities) error) {
+	ctx := context.Background()
+	_, matchMakerUsers := createTestMatchMaker(b, repo, benchmarkParticipants)
+
+	statuses := []MatchMakerUserStatus{MatchMakerUserStatusStopped, MatchMakerUserStatusRunning}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, matchMakerUser := range matchMakerUsers {
+			matchMakerUser.Status = statuses[i%len(statuses)]
+		}
+
+		if err := update(ctx, matchMakerUsers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"connectrpc.com/connect"
+)
+
+const (
+	RequestIDHeader = "X-Request-Id"
+
+	AnonymousActor = "anonymous"
+	CLIActor       = "cli"
+	SystemActor    = "system"
+
+	maxRequestMetadataLength = 255
+)
+
+type requestMetadataKey struct{}
+
+// RequestMetadata identifies who made a request and the request itself, it is carried in the context.
+// Authenticated tells whether the actor was proven by a credential rather than assumed.
+type RequestMetadata struct {
+	Actor         string
+	RequestID     string
+	Authenticated bool
+}
+
+func WithRequestMetadata(ctx context.Context, metadata RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, metadata)
+}
+
+// RequestMetadataFromContext returns the metadata of the request, the actor is anonymous when none was given.
+func RequestMetadataFromContext(ctx context.Context) RequestMetadata {
+	metadata, _ := ctx.Value(requestMetadataKey{}).(RequestMetadata)
+	if metadata.Actor == "" {
+		metadata.Actor = AnonymousActor
+	}
+	return metadata
+}
+
+// NewRequestMetadataMiddleware stores the actor and the request id of every request in its context.
+// The actor is the name of the API key sent as a bearer token, the request is anonymous without one and
+// refused with a key that is not configured. The request id is generated when the caller didn't send one
+// and is echoed in the response.
+func NewRequestMetadataMiddleware(auth *Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata := RequestMetadata{
+			RequestID: truncate(r.Header.Get(RequestIDHeader), maxRequestMetadataLength),
+		}
+		if metadata.RequestID == "" {
+			metadata.RequestID = GenerateSerial()
+		}
+
+		w.Header().Set(RequestIDHeader, metadata.RequestID)
+
+		name, ok, err := auth.authenticate(r)
+		if err != nil {
+			writeUnauthenticated(w)
+			return
+		}
+		if ok {
+			metadata.Actor = APIKeyActorPrefix + name
+			metadata.Authenticated = true
+		}
+
+		// Track the writes of the request so that its following reads skip the replicas
+		ctx := WithWriteTracking(WithRequestMetadata(r.Context(), metadata))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestCaller tells the callers apart, for the rate limits and the idempotency keys. It is the actor only when
+// a credential proved it, an actor that is merely claimed would let a caller pass for as many others as it likes,
+// and the host of the peer otherwise.
+func requestCaller(ctx context.Context, peer connect.Peer) string {
+	if metadata := RequestMetadataFromContext(ctx); metadata.Authenticated {
+		return "actor:" + metadata.Actor
+	}
+
+	host, _, err := net.SplitHostPort(peer.Addr)
+	if err != nil {
+		host = peer.Addr
+	}
+	return "peer:" + host
+}
+
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// RESTPrefix is the path of the REST API on the mux, its routes are listed by restRoutes.
+	RESTPrefix = "/v1/"
+
+	OpenAPIPath = "/v1/openapi.json"
+
+	// RESTProcedurePrefix names the routes as the procedures of a REST service, next to the procedures of the
+	// Connect services in the traces, the metrics and the idempotency keys.
+	RESTProcedurePrefix = "/donut.v1.REST/"
+)
+
+type restMatchMakerRequest struct {
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"`
+	StartTime    *time.Time `json:"start_time,omitempty"`
+	DurationDays int32      `json:"duration_days"`
+}
+
+type restMatchMakerResponse struct {
+	Serial       string           `json:"serial"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	Status       MatchMakerStatus `json:"status"`
+	StartTime    time.Time        `json:"start_time"`
+	DurationDays int32            `json:"duration_days"`
+}
+
+type restSerialResponse struct {
+	Serial string `json:"serial"`
+}
+
+type restPeopleRequest struct {
+	References []string `json:"references"`
+}
+
+type restErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// restQueryParameter documents a query parameter of a route.
+type restQueryParameter struct {
+	Name        string
+	Description string
+}
+
+// restRoute maps a method and a path on DonutCall. The path segments in braces are parameters, the request
+// and response hold a value of the body types, which the OpenAPI document describes.
+type restRoute struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Query       []restQueryParameter
+	Request     interface{}
+	Response    interface{}
+	Status      int
+	Handle      func(w http.ResponseWriter, r *http.Request, params map[string]string)
+}
+
+// RESTHandler serves the REST API for the clients that can't use Connect or gRPC. The requests are converted
+// to the messages of the RPCs, so they are validated by the same rules.
+type RESTHandler struct {
+	handler     *Handler
+	routes      []restRoute
+	middlewares []RESTMiddleware
+
+	documentOnce sync.Once
+	document     []byte
+	documentErr  error
+}
+
+// RESTMiddleware wraps the routes of the REST API as an interceptor wraps the procedures, the procedure is the
+// operation of the route prefixed by RESTProcedurePrefix.
+type RESTMiddleware func(procedure string, next http.HandlerFunc) http.HandlerFunc
+
+// NewRESTHandler returns the handler of the REST API, the first middleware is the outermost.
+func NewRESTHandler(handler *Handler, middlewares ...RESTMiddleware) *RESTHandler {
+	h := &RESTHandler{
+		handler:     handler,
+		middlewares: middlewares,
+	}
+	h.routes = h.restRoutes()
+	return h
+}
+
+func (h *RESTHandler) restRoutes() []restRoute {
+	serialPath := RESTPrefix + "matchmakers/{serial}"
+
+	return []restRoute{
+		{
+			Method:      http.MethodGet,
+			Path:        RESTPrefix + "matchmakers",
+			OperationID: "listMatchMakers",
+			Summary:     "List the match makers, newest first",
+			Query:       []restQueryParameter{{Name: "status", Description: "Comma separated statuses to list, every status by default"}},
+			Response:    []restMatchMakerResponse{},
+			Status:      http.StatusOK,
+			Handle:      h.listMatchMakers,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        RESTPrefix + "matchmakers",
+			OperationID: "createMatchMaker",
+			Summary:     "Create a match maker",
+			Request:     restMatchMakerRequest{},
+			Response:    restSerialResponse{},
+			Status:      http.StatusCreated,
+			Handle:      h.createMatchMaker,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        serialPath,
+			OperationID: "getMatchMaker",
+			Summary:     "Get a match maker",
+			Response:    restMatchMakerResponse{},
+			Status:      http.StatusOK,
+			Handle:      h.getMatchMaker,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        serialPath + "/start",
+			OperationID: "startMatchMaker",
+			Summary:     "Pair the people and start the match maker",
+			Status:      http.StatusNoContent,
+			Handle:      h.startMatchMaker,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        serialPath + "/stop",
+			OperationID: "stopMatchMaker",
+			Summary:     "Stop the match maker",
+			Status:      http.StatusNoContent,
+			Handle:      h.stopMatchMaker,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        serialPath + "/people",
+			OperationID: "listPeople",
+			Summary:     "List the people of a match maker with their pair and status",
+			Response:    []MatchMakerUserExport{},
+			Status:      http.StatusOK,
+			Handle:      h.listPeople,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        serialPath + "/people",
+			OperationID: "registerPeople",
+			Summary:     "Register people to a match maker",
+			Request:     restPeopleRequest{},
+			Status:      http.StatusNoContent,
+			Handle:      h.registerPeople,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        serialPath + "/people/{reference}",
+			OperationID: "unregisterPerson",
+			Summary:     "Unregister a person from a match maker",
+			Status:      http.StatusNoContent,
+			Handle:      h.unregisterPerson,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        serialPath + "/pairs",
+			OperationID: "listPairs",
+			Summary:     "List the pairs of a match maker",
+			Response:    []PairExport{},
+			Status:      http.StatusOK,
+			Handle:      h.listPairs,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        serialPath + "/pairs/call",
+			OperationID: "callPair",
+			Summary:     "Finish the pair of the given people after their call",
+			Request:     restPeopleRequest{},
+			Status:      http.StatusNoContent,
+			Handle:      h.callPair,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        OpenAPIPath,
+			OperationID: "getOpenAPIDocument",
+			Summary:     "Get this OpenAPI document",
+			Status:      http.StatusOK,
+			Handle:      h.getOpenAPIDocument,
+		},
+	}
+}
+
+// ServeHTTP finds the route of the request, answering 404 for an unknown path and 405 for an unknown method.
+func (h *RESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathMatched := false
+	for _, route := range h.routes {
+		params, ok := matchRESTPath(route.Path, r.URL.EscapedPath())
+		if !ok {
+			continue
+		}
+		pathMatched = true
+
+		if route.Method == r.Method {
+			h.serve(route, params)(w, r)
+			return
+		}
+	}
+
+	if pathMatched {
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	writeHTTPError(w, http.StatusNotFound, fmt.Errorf("%s is not found", r.URL.Path))
+}
+
+// serve wraps the route with the middlewares.
+func (h *RESTHandler) serve(route restRoute, params map[string]string) http.HandlerFunc {
+	next := func(w http.ResponseWriter, r *http.Request) {
+		route.Handle(w, r, params)
+	}
+
+	procedure := RESTProcedurePrefix + route.OperationID
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		next = h.middlewares[i](procedure, next)
+	}
+	return next
+}
+
+// restResponseRecorder records the status of the response for the middlewares, and its body when it is given
+// a buffer.
+type restResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (r *restResponseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *restResponseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.body != nil {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (r *restResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *restResponseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// err returns an error with the code of the status of a failed response, for the middlewares sharing their
+// logic with the interceptors.
+func (r *restResponseRecorder) err() error {
+	status := r.statusCode()
+	if status < http.StatusBadRequest {
+		return nil
+	}
+	return connect.NewError(ConnectCode(status), errors.New(http.StatusText(status)))
+}
+
+// matchRESTPath matches the escaped path against the pattern and returns the unescaped values of its parameters,
+// so that a parameter may hold a slash.
+func matchRESTPath(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(pathSegments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[strings.Trim(segment, "{}")] = value
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// validate applies the rules of the RPC message the request is converted to, see ValidateRequest.
+func (h *RESTHandler) validate(w http.ResponseWriter, msg interface{}) bool {
+	err := ValidateRequest(msg, h.handler.cfg.ValidationConfig)
+	if err == nil {
+		return true
+	}
+
+	writeHTTPError(w, HTTPStatus(err), errors.New(ErrorMessage(err)))
+	return false
+}
+
+func (h *RESTHandler) decode(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func (h *RESTHandler) listMatchMakers(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	var statuses []MatchMakerStatus
+	if status := r.URL.Query().Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			statuses = append(statuses, MatchMakerStatus(strings.TrimSpace(s)))
+		}
+	}
+
+	matchMakers, err := h.handler.svc.ListMatchMakers(r.Context(), statuses)
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	resp := make([]restMatchMakerResponse, 0, len(matchMakers))
+	for _, matchMaker := range matchMakers {
+		resp = append(resp, newRESTMatchMakerResponse(matchMaker))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *RESTHandler) createMatchMaker(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	var body restMatchMakerRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	msg := &donutv1.CreateMatchMakerRequest{
+		MatchMaker: &donutv1.MatchMaker{
+			Name:        body.Name,
+			Description: body.Description,
+			Duration:    body.DurationDays,
+		},
+	}
+	if body.StartTime != nil {
+		msg.MatchMaker.StartTime = timestamppb.New(*body.StartTime)
+	}
+	if !h.validate(w, msg) {
+		return
+	}
+
+	serial, err := h.handler.svc.CreateMatchMaker(r.Context(), parseCreateMatchMakerRequest(connect.NewRequest(msg)))
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Location", RESTPrefix+"matchmakers/"+serial)
+	writeJSON(w, http.StatusCreated, restSerialResponse{
+		Serial: serial,
+	})
+}
+
+func (h *RESTHandler) getMatchMaker(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	info, ok := h.getInformation(w, r, params)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRESTMatchMakerResponse(info.MatchMaker))
+}
+
+func (h *RESTHandler) startMatchMaker(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	msg := &donutv1.StartMatchMakerRequest{Serial: params["serial"]}
+	h.noContent(w, r, msg, func(ctx context.Context) error {
+		return h.handler.svc.Start(ctx, msg.GetSerial())
+	})
+}
+
+func (h *RESTHandler) stopMatchMaker(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	msg := &donutv1.StopMatchMakerRequest{Serial: params["serial"]}
+	h.noContent(w, r, msg, func(ctx context.Context) error {
+		return h.handler.svc.Stop(ctx, msg.GetSerial())
+	})
+}
+
+func (h *RESTHandler) listPeople(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	info, ok := h.getInformation(w, r, params)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewMatchMakerExport(info).People)
+}
+
+func (h *RESTHandler) registerPeople(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var body restPeopleRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	people := make(MatchMakerUserEntities, 0, len(body.References))
+	for _, reference := range body.References {
+		msg := &donutv1.RegisterPeopleRequest{MatchmakerSerial: params["serial"], Reference: reference}
+		if !h.validate(w, msg) {
+			return
+		}
+		people = append(people, parseRegisterPeopleRequest(msg)...)
+	}
+	if len(people) == 0 {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("references is required"))
+		return
+	}
+
+	h.noContent(w, r, nil, func(ctx context.Context) error {
+		return h.handler.svc.RegisterPeople(ctx, people)
+	})
+}
+
+func (h *RESTHandler) unregisterPerson(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	msg := &donutv1.UnRegisterPeopleRequest{MatchmakerSerial: params["serial"], Reference: params["reference"]}
+	h.noContent(w, r, msg, func(ctx context.Context) error {
+		return h.handler.svc.UnRegisterPeople(ctx, parseUnRegisterPeopleRequest(msg))
+	})
+}
+
+func (h *RESTHandler) listPairs(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	info, ok := h.getInformation(w, r, params)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewMatchMakerExport(info).Pairs)
+}
+
+func (h *RESTHandler) callPair(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var body restPeopleRequest
+	if !h.decode(w, r, &body) {
+		return
+	}
+
+	req := connect.NewRequest(&donutv1.CallPeopleRequest{MatchmakerSerial: params["serial"], References: body.References})
+	h.noContent(w, r, req.Msg, func(ctx context.Context) error {
+		return h.handler.svc.Call(ctx, req.Msg.GetMatchmakerSerial(), parseCallPeopleRequest(req).ToPeople())
+	})
+}
+
+func (h *RESTHandler) getOpenAPIDocument(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	h.documentOnce.Do(func() {
+		h.document, h.documentErr = json.MarshalIndent(NewOpenAPIDocument(h.routes), "", "  ")
+	})
+	if h.documentErr != nil {
+		writeHTTPError(w, http.StatusInternalServerError, h.documentErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(h.document)
+}
+
+// getInformation validates the serial of the path and returns the match maker with its people.
+func (h *RESTHandler) getInformation(w http.ResponseWriter, r *http.Request, params map[string]string) (*MatchMakerInformation, bool) {
+	msg := &donutv1.GetMatchMakerInformationRequest{Serial: params["serial"]}
+	if !h.validate(w, msg) {
+		return nil, false
+	}
+
+	info, err := h.handler.svc.GetInformation(r.Context(), msg.GetSerial())
+	if err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return nil, false
+	}
+	return info, true
+}
+
+// noContent validates the message, when there is one, and answers 204 No Content once fn succeeded.
+func (h *RESTHandler) noContent(w http.ResponseWriter, r *http.Request, msg interface{}, fn func(ctx context.Context) error) {
+	if msg != nil && !h.validate(w, msg) {
+		return
+	}
+
+	if err := fn(r.Context()); err != nil {
+		writeHTTPError(w, HTTPStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newRESTMatchMakerResponse(matchMaker *MatchMakerEntity) restMatchMakerResponse {
+	return restMatchMakerResponse{
+		Serial:       matchMaker.Serial,
+		Name:         matchMaker.Name,
+		Description:  matchMaker.Description,
+		Status:       matchMaker.Status,
+		StartTime:    matchMaker.StartTime,
+		DurationDays: int32(matchMaker.Duration / Day),
+	}
+}
+
+// restPathParameters returns the parameters of a route path in order.
+func restPathParameters(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, strings.Trim(segment, "{}"))
+		}
+	}
+	return params
+}
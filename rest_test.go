@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMatchRESTPath(t *testing.T) {
+	const (
+		serialPattern    = RESTPrefix + "matchmakers/{serial}"
+		referencePattern = serialPattern + "/people/{reference}"
+	)
+
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		params  map[string]string
+	}{
+		{"static", RESTPrefix + "matchmakers", "/v1/matchmakers", map[string]string{}},
+		{"trailing slash", RESTPrefix + "matchmakers", "/v1/matchmakers/", map[string]string{}},
+		{"parameter", serialPattern, "/v1/matchmakers/abc", map[string]string{"serial": "abc"}},
+		{"encoded slash", referencePattern, "/v1/matchmakers/abc/people/team%2Fann", map[string]string{"serial": "abc", "reference": "team/ann"}},
+		{"encoded characters", referencePattern, "/v1/matchmakers/abc/people/%3C%40U123%3E", map[string]string{"serial": "abc", "reference": "<@U123>"}},
+		{"unencoded slash", referencePattern, "/v1/matchmakers/abc/people/team/ann", nil},
+		{"empty parameter", referencePattern, "/v1/matchmakers//people/ann", nil},
+		{"empty last parameter", referencePattern, "/v1/matchmakers/abc/people/", nil},
+		{"invalid escape", serialPattern, "/v1/matchmakers/%zz", nil},
+		{"other static segment", serialPattern + "/start", "/v1/matchmakers/abc/stop", nil},
+		{"longer path", serialPattern, "/v1/matchmakers/abc/start", nil},
+		{"shorter path", serialPattern + "/start", "/v1/matchmakers/abc", nil},
+	}
+
+	for _, test := range tests {
+		params, ok := matchRESTPath(test.pattern, test.path)
+		if ok != (test.params != nil) {
+			t.Errorf("%s: expected the match to be %v but got %v", test.name, test.params != nil, ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: expected the parameters %v but got %v", test.name, test.params, params)
+		}
+	}
+}
+
+// TestRESTHandlerRouting serves the requests through a middleware answering in place of the routes, so only the
+// routing is exercised.
+func TestRESTHandlerRouting(t *testing.T) {
+	var procedure string
+	h := NewRESTHandler(&Handler{}, func(p string, next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			procedure = p
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+
+	tests := []struct {
+		method    string
+		path      string
+		status    int
+		procedure string
+	}{
+		{http.MethodGet, "/v1/matchmakers", http.StatusTeapot, "listMatchMakers"},
+		{http.MethodPost, "/v1/matchmakers", http.StatusTeapot, "createMatchMaker"},
+		{http.MethodGet, "/v1/matchmakers/abc", http.StatusTeapot, "getMatchMaker"},
+		{http.MethodDelete, "/v1/matchmakers/abc/people/team%2Fann", http.StatusTeapot, "unregisterPerson"},
+		{http.MethodPost, "/v1/matchmakers/abc/pairs/call", http.StatusTeapot, "callPair"},
+		{http.MethodDelete, "/v1/matchmakers", http.StatusMethodNotAllowed, ""},
+		{http.MethodPut, "/v1/matchmakers/abc", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/v1/matchmakers/abc/start", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, OpenAPIPath, http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/v1/matchmakers/abc/people/team/ann", http.StatusNotFound, ""},
+		{http.MethodDelete, "/v1/matchmakers//people/ann", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/matchmakers/abc/unknown", http.StatusNotFound, ""},
+		{http.MethodGet, "/v1/unknown", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		procedure = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected the status %d but got %d", test.method, test.path, test.status, w.Code)
+		}
+		if expected := RESTProcedurePrefix + test.procedure; test.procedure != "" && procedure != expected {
+			t.Errorf("%s %s: expected the procedure %s but got %s", test.method, test.path, expected, procedure)
+		}
+		if test.procedure == "" && procedure != "" {
+			t.Errorf("%s %s: expected no route to be served but got %s", test.method, test.path, procedure)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const archiveBatchSize = 100
+
+type RetentionConfig struct {
+	Interval            time.Duration `env:"RETENTION_INTERVAL" envDefault:"24h"`
+	DeletedPeople       time.Duration `env:"RETENTION_DELETED_PEOPLE" envDefault:"720h"`
+	FinishedMatchMakers time.Duration `env:"RETENTION_FINISHED_MATCHMAKERS" envDefault:"2160h"`
+}
+
+// MatchMakerArchive keeps a finished match maker after it left the matchmaker table.
+type MatchMakerArchive struct {
+	Serial      string `gorm:"uniqueIndex;size:36"`
+	Name        string
+	Description string
+	Status      MatchMakerStatus
+	StartTime   time.Time
+	EndTime     time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ArchivedAt  time.Time
+}
+
+func (MatchMakerArchive) TableName() string {
+	return "matchmaker_archive"
+}
+
+func (MatchMakerArchive) FromMatchMaker(matchMaker *MatchMaker, archivedAt time.Time) *MatchMakerArchive {
+	return &MatchMakerArchive{
+		Serial:      matchMaker.Serial,
+		Name:        matchMaker.Name,
+		Description: matchMaker.Description,
+		Status:      matchMaker.Status,
+		StartTime:   matchMaker.StartTime,
+		EndTime:     matchMaker.EndTime,
+		CreatedAt:   matchMaker.CreatedAt,
+		UpdatedAt:   matchMaker.UpdatedAt,
+		ArchivedAt:  archivedAt,
+	}
+}
+
+// MatchMakerUserArchive keeps the users of an archived match maker, unregistered ones included.
+type MatchMakerUserArchive struct {
+	ID               uint64 `gorm:"primaryKey;autoIncrement"`
+	MatchMakerSerial string `gorm:"column:matchmaker_serial;index;size:36"`
+	Serial           string `gorm:"index;size:36"`
+	UserReference    string `gorm:"index;size:255"`
+	Status           MatchMakerUserStatus
+	DeletedAt        *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	ArchivedAt       time.Time
+}
+
+func (MatchMakerUserArchive) TableName() string {
+	return "matchmaker_user_archive"
+}
+
+type MatchMakerUserArchives []*MatchMakerUserArchive
+
+func (MatchMakerUserArchives) FromMatchMakerUsers(matchMakerUsers MatchMakerUsers, archivedAt time.Time) MatchMakerUserArchives {
+	archives := make(MatchMakerUserArchives, 0, len(matchMakerUsers))
+	for _, matchMakerUser := range matchMakerUsers {
+		if matchMakerUser == nil {
+			continue
+		}
+
+		var deletedAt *time.Time
+		if matchMakerUser.DeletedAt.Valid {
+			deletedAt = &matchMakerUser.DeletedAt.Time
+		}
+
+		archives = append(archives, &MatchMakerUserArchive{
+			MatchMakerSerial: matchMakerUser.MatchMakerSerial,
+			Serial:           matchMakerUser.Serial,
+			UserReference:    matchMakerUser.UserReference,
+			Status:           matchMakerUser.Status,
+			DeletedAt:        deletedAt,
+			CreatedAt:        matchMakerUser.CreatedAt,
+			UpdatedAt:        matchMakerUser.UpdatedAt,
+			ArchivedAt:       archivedAt,
+		})
+	}
+	return archives
+}
+
+// RunRetention applies the retention policies every interval until the context is done.
+func RunRetention(ctx context.Context, donut DonutCall, cfg RetentionConfig) {
+	ctx = WithRequestMetadata(ctx, RequestMetadata{Actor: SystemActor})
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := donut.PurgeDeletedPeople(ctx, time.Now().Add(-cfg.DeletedPeople))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to purge unregistered people")
+		} else {
+			log.Info().Int64("purged", purged).Msg("purged unregistered people past retention")
+		}
+
+		archived, err := donut.ArchiveMatchMakers(ctx, time.Now().Add(-cfg.FinishedMatchMakers))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to archive finished match makers")
+		} else {
+			log.Info().Int64("archived", archived).Msg("archived finished match makers past retention")
+		}
+	}
+}
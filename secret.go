@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// sealedSecretPrefix marks the secrets stored encrypted, the others were stored before the encryption
+	sealedSecretPrefix = "enc:v1:"
+
+	minSecretKeyLength = 32
+)
+
+var ErrSecretKeyMissing = errors.New("secret key is not configured")
+
+// SecretBox encrypts the secrets stored in the database with AES-GCM, under a key derived from the configured one.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox returns the box of the key, without a key it can only open the secrets stored in plain text.
+func NewSecretBox(key string) (*SecretBox, error) {
+	if key == "" {
+		return &SecretBox{}, nil
+	}
+
+	derived := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+func (b *SecretBox) Seal(secret string) (string, error) {
+	if b.aead == nil {
+		return "", ErrSecretKeyMissing
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed secret, a secret stored in plain text is returned as is.
+func (b *SecretBox) Open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedSecretPrefix)
+	if !ok {
+		return value, nil
+	}
+	if b.aead == nil {
+		return "", ErrSecretKeyMissing
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", fmt.Errorf("sealed secret is malformed")
+	}
+
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to open sealed secret: %w", err)
+	}
+
+	return string(secret), nil
+}
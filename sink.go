@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	SinkLog     = "log"
+	SinkWebhook = "webhook"
+	SinkRedis   = "redis"
+	SinkNotify  = "notify"
+)
+
+// EventSink receives the events relayed from the outbox.
+// Publish may be called more than once for the same event, sinks should deduplicate on the event serial.
+type EventSink interface {
+	Name() string
+	Publish(ctx context.Context, event *EventEntity) error
+}
+
+type RedisConfig struct {
+	Address  string `env:"REDIS_ADDRESS" envDefault:"localhost:6379"`
+	Password string `env:"REDIS_PASSWORD" secret:"true"`
+	DB       int    `env:"REDIS_DB" envDefault:"0"`
+}
+
+func NewRedisClient(cfg RedisConfig) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     cfg.Address,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+}
+
+type logSink struct{}
+
+func NewLogSink() EventSink {
+	return &logSink{}
+}
+
+func (s *logSink) Name() string {
+	return SinkLog
+}
+
+func (s *logSink) Publish(ctx context.Context, event *EventEntity) error {
+	log.Info().
+		Str("event", event.Serial).
+		Str("type", string(event.Type)).
+		Str("matchmaker", event.MatchMakerSerial).
+		RawJSON("payload", event.Payload).
+		Msg("domain event")
+	return nil
+}
+
+// redisStreamSink appends the events to a redis stream, consumers read it with consumer groups.
+type redisStreamSink struct {
+	client *redis.Client
+	stream string
+}
+
+func NewRedisStreamSink(client *redis.Client, stream string) EventSink {
+	return &redisStreamSink{
+		client: client,
+		stream: stream,
+	}
+}
+
+func (s *redisStreamSink) Name() string {
+	return SinkRedis
+}
+
+func (s *redisStreamSink) Publish(ctx context.Context, event *EventEntity) error {
+	body, err := event.Envelope()
+	if err != nil {
+		return err
+	}
+
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		Values: map[string]interface{}{
+			"id":         event.Serial,
+			"type":       string(event.Type),
+			"matchmaker": event.MatchMakerSerial,
+			"body":       body,
+		},
+	}).Err()
+}
+
+// NewEventSinks builds the sinks named in the outbox configuration.
+func NewEventSinks(cfg *Config, webhooks *WebhookDispatcher, notifications *NotificationDispatcher) ([]EventSink, error) {
+	sinks := make([]EventSink, 0, len(cfg.OutboxConfig.Sinks))
+
+	for _, name := range cfg.OutboxConfig.Sinks {
+		switch name {
+		case SinkLog:
+			sinks = append(sinks, NewLogSink())
+		case SinkWebhook:
+			sinks = append(sinks, webhooks)
+		case SinkRedis:
+			sinks = append(sinks, NewRedisStreamSink(NewRedisClient(cfg.RedisConfig), cfg.OutboxConfig.Stream))
+		case SinkNotify:
+			sinks = append(sinks, notifications)
+		default:
+			return nil, fmt.Errorf("unsupported event sink: %s", name)
+		}
+	}
+
+	return sinks, nil
+}
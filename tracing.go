@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"connectrpc.com/connect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName = "github.com/mocha-bot/donut"
+
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+
+	gormTracingSpanKey = "donut:tracing_span"
+)
+
+type TracingConfig struct {
+	Exporter     string  `env:"TRACING_EXPORTER" envDefault:"none" enum:"none,stdout,otlp"`
+	ServiceName  string  `env:"TRACING_SERVICE_NAME" envDefault:"donut"`
+	OTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT" envDefault:"localhost:4318"`
+	OTLPInsecure bool    `env:"TRACING_OTLP_INSECURE" envDefault:"true"`
+	SampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+}
+
+// SetupTracing installs the global tracer provider and propagator, the returned function flushes
+// the remaining spans and has to be called on shutdown. With the none exporter the global no-op provider is kept.
+func SetupTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "", TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type tracingInterceptor struct{}
+
+// NewTracingInterceptor starts a server span for every procedure, continuing the trace of the caller
+// when the request carries W3C trace context headers.
+func NewTracingInterceptor() connect.Interceptor {
+	return &tracingInterceptor{}
+}
+
+func (i *tracingInterceptor) start(ctx context.Context, spec connect.Spec, header propagation.HeaderCarrier) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, header)
+
+	service, method := splitProcedure(spec.Procedure)
+	return tracer().Start(ctx, strings.TrimPrefix(spec.Procedure, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("connect_rpc"),
+			semconv.RPCService(service),
+			semconv.RPCMethod(method),
+		),
+	)
+}
+
+func (i *tracingInterceptor) end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		span.SetAttributes(attribute.String("rpc.connect_rpc.error_code", connect.CodeOf(err).String()))
+		endSpan(span, err)
+		return
+	}
+	span.End()
+}
+
+func (i *tracingInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		ctx, span := i.start(ctx, req.Spec(), propagation.HeaderCarrier(req.Header()))
+		resp, err := next(ctx, req)
+		i.end(span, err)
+		return resp, err
+	}
+}
+
+func (i *tracingInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *tracingInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx, span := i.start(ctx, conn.Spec(), propagation.HeaderCarrier(conn.RequestHeader()))
+		err := next(ctx, conn)
+		i.end(span, err)
+		return err
+	}
+}
+
+// NewRESTTracingMiddleware starts a server span for every route of the REST API, as the tracing interceptor does
+// for the procedures.
+func NewRESTTracingMiddleware() RESTMiddleware {
+	interceptor := &tracingInterceptor{}
+
+	return func(procedure string, next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := interceptor.start(r.Context(), connect.Spec{Procedure: procedure}, propagation.HeaderCarrier(r.Header))
+			recorder := &restResponseRecorder{ResponseWriter: w}
+			next(recorder, r.WithContext(ctx))
+			span.SetAttributes(semconv.HTTPMethod(r.Method), semconv.HTTPStatusCode(recorder.statusCode()))
+			interceptor.end(span, recorder.err())
+		}
+	}
+}
+
+// splitProcedure splits "/donut.v1.PeopleService/GetPeople" into its service and method.
+func splitProcedure(procedure string) (string, string) {
+	procedure = strings.TrimPrefix(procedure, "/")
+	if idx := strings.LastIndex(procedure, "/"); idx >= 0 {
+		return procedure[:idx], procedure[idx+1:]
+	}
+	return procedure, ""
+}
+
+type gormTracingPlugin struct{}
+
+// NewGormTracingPlugin creates a client span for every statement executed through gorm,
+// as a child of the span in the statement context.
+func NewGormTracingPlugin() gorm.Plugin {
+	return &gormTracingPlugin{}
+}
+
+func (p *gormTracingPlugin) Name() string {
+	return "donut:tracing"
+}
+
+func (p *gormTracingPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("gorm:create").Register("donut:tracing_before_create", p.before("create")),
+		callback.Create().After("gorm:create").Register("donut:tracing_after_create", p.after),
+		callback.Query().Before("gorm:query").Register("donut:tracing_before_query", p.before("query")),
+		callback.Query().After("gorm:query").Register("donut:tracing_after_query", p.after),
+		callback.Update().Before("gorm:update").Register("donut:tracing_before_update", p.before("update")),
+		callback.Update().After("gorm:update").Register("donut:tracing_after_update", p.after),
+		callback.Delete().Before("gorm:delete").Register("donut:tracing_before_delete", p.before("delete")),
+		callback.Delete().After("gorm:delete").Register("donut:tracing_after_delete", p.after),
+		callback.Row().Before("gorm:row").Register("donut:tracing_before_row", p.before("row")),
+		callback.Row().After("gorm:row").Register("donut:tracing_after_row", p.after),
+		callback.Raw().Before("gorm:raw").Register("donut:tracing_before_raw", p.before("raw")),
+		callback.Raw().After("gorm:raw").Register("donut:tracing_after_raw", p.after),
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *gormTracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		ctx, span := tracer().Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(db.Dialector.Name())),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(gormTracingSpanKey, span)
+	}
+}
+
+func (p *gormTracingPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormTracingSpanKey)
+	if !ok {
+		return
+	}
+
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		semconv.DBSQLTable(db.Statement.Table),
+		semconv.DBStatement(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxNameLength        = 255
+	maxDescriptionLength = 1000
+)
+
+// referencePattern accepts the user references of the chat platforms, which never hold whitespace.
+var referencePattern = regexp.MustCompile(`^\S{1,255}$`)
+
+type ValidationConfig struct {
+	MaxDurationDays    int32         `env:"VALIDATION_MAX_DURATION_DAYS" envDefault:"365"`
+	StartTimeTolerance time.Duration `env:"VALIDATION_START_TIME_TOLERANCE" envDefault:"5m"`
+}
+
+// requestValidator collects the violations of a request, each rule adds one when its field is invalid.
+type requestValidator struct {
+	cfg        ValidationConfig
+	now        time.Time
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (v *requestValidator) violate(field, format string, args ...interface{}) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *requestValidator) present(field string, present bool) bool {
+	if !present {
+		v.violate(field, "is required")
+	}
+	return present
+}
+
+func (v *requestValidator) serial(field, value string) {
+	if value == "" {
+		v.violate(field, "is required")
+		return
+	}
+	if _, err := uuid.Parse(value); err != nil {
+		v.violate(field, "is not a valid serial")
+	}
+}
+
+func (v *requestValidator) reference(field, value string) {
+	if value == "" {
+		v.violate(field, "is required")
+		return
+	}
+	if !referencePattern.MatchString(value) {
+		v.violate(field, "must be at most 255 characters without whitespace")
+	}
+}
+
+func (v *requestValidator) maxLength(field, value string, length int) {
+	if len([]rune(value)) > length {
+		v.violate(field, "must be at most %d characters", length)
+	}
+}
+
+func (v *requestValidator) durationDays(field string, value int32) {
+	if value < 1 || value > v.cfg.MaxDurationDays {
+		v.violate(field, "must be between 1 and %d days", v.cfg.MaxDurationDays)
+	}
+}
+
+// notInPast accepts a missing time, which defaults to now, and times slightly in the past to allow for clock skew.
+func (v *requestValidator) notInPast(field string, value *timestamppb.Timestamp) {
+	if value == nil {
+		return
+	}
+	if err := value.CheckValid(); err != nil {
+		v.violate(field, "is not a valid time")
+		return
+	}
+	if value.AsTime().Before(v.now.Add(-v.cfg.StartTimeTolerance)) {
+		v.violate(field, "must not be in the past")
+	}
+}
+
+// validateRequest declares the rules of every request message, the fields are named as in the proto.
+func (v *requestValidator) validateRequest(msg interface{}) {
+	switch m := msg.(type) {
+	case *donutv1.CreateMatchMakerRequest:
+		if !v.present("match_maker", m.GetMatchMaker() != nil) {
+			return
+		}
+		matchMaker := m.GetMatchMaker()
+		v.maxLength("match_maker.name", strings.TrimSpace(matchMaker.GetName()), maxNameLength)
+		v.maxLength("match_maker.description", matchMaker.GetDescription(), maxDescriptionLength)
+		v.notInPast("match_maker.start_time", matchMaker.GetStartTime())
+		v.durationDays("match_maker.duration", matchMaker.GetDuration())
+	case *donutv1.GetMatchMakerInformationRequest:
+		v.serial("serial", m.GetSerial())
+	case *donutv1.StartMatchMakerRequest:
+		v.serial("serial", m.GetSerial())
+	case *donutv1.StopMatchMakerRequest:
+		v.serial("serial", m.GetSerial())
+	case *donutv1.GetPeopleRequest:
+		v.serial("matchmaker_serial", m.GetMatchmakerSerial())
+	case *donutv1.GetPeoplePairRequest:
+		v.serial("matchmaker_serial", m.GetMatchmakerSerial())
+	case *donutv1.RegisterPeopleRequest:
+		v.serial("matchmaker_serial", m.GetMatchmakerSerial())
+		v.reference("reference", m.GetReference())
+	case *donutv1.UnRegisterPeopleRequest:
+		v.serial("matchmaker_serial", m.GetMatchmakerSerial())
+		v.reference("reference", m.GetReference())
+	case *donutv1.CallPeopleRequest:
+		v.serial("matchmaker_serial", m.GetMatchmakerSerial())
+		if v.present("references", len(m.GetReferences()) > 0) {
+			for i, reference := range m.GetReferences() {
+				v.reference(fmt.Sprintf("references[%d]", i), reference)
+			}
+		}
+	}
+}
+
+// ValidateRequest returns an InvalidArgument error listing every invalid field of the request, or nil.
+func ValidateRequest(msg interface{}, cfg ValidationConfig) error {
+	v := &requestValidator{cfg: cfg, now: time.Now()}
+	v.validateRequest(msg)
+
+	if len(v.violations) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(v.violations))
+	for _, violation := range v.violations {
+		descriptions = append(descriptions, fmt.Sprintf("%s %s", violation.GetField(), violation.GetDescription()))
+	}
+
+	err := fmt.Errorf("invalid request: %s", strings.Join(descriptions, ", "))
+	return newConnectError(connect.CodeInvalidArgument, err, errorReasons[ErrValidation], nil, &errdetails.BadRequest{
+		FieldViolations: v.violations,
+	})
+}
+
+type validationInterceptor struct {
+	cfg ValidationConfig
+}
+
+// NewValidationInterceptor rejects the invalid requests before they reach the handlers, every message
+// of a stream is validated as it is received.
+func NewValidationInterceptor(cfg ValidationConfig) connect.Interceptor {
+	return &validationInterceptor{
+		cfg: cfg,
+	}
+}
+
+func (i *validationInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if !req.Spec().IsClient {
+			if err := ValidateRequest(req.Any(), i.cfg); err != nil {
+				return nil, err
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *validationInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i *validationInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		return next(ctx, &validatingHandlerConn{StreamingHandlerConn: conn, cfg: i.cfg})
+	}
+}
+
+type validatingHandlerConn struct {
+	connect.StreamingHandlerConn
+	cfg ValidationConfig
+}
+
+func (c *validatingHandlerConn) Receive(msg interface{}) error {
+	if err := c.StreamingHandlerConn.Receive(msg); err != nil {
+		return err
+	}
+	return ValidateRequest(msg, c.cfg)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	donutv1 "buf.build/gen/go/mocha/remcall/protocolbuffers/go/donut/v1"
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var testValidationConfig = ValidationConfig{
+	MaxDurationDays:    30,
+	StartTimeTolerance: 5 * time.Minute,
+}
+
+// connectErrorDetails returns the code and the details attached to a connect error.
+func connectErrorDetails(t *testing.T, err error) (connect.Code, []proto.Message) {
+	t.Helper()
+
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		t.Fatalf("expected a connect error but got %v", err)
+	}
+
+	var details []proto.Message
+	for _, detail := range connectErr.Details() {
+		message, err := detail.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, message)
+	}
+	return connectErr.Code(), details
+}
+
+// fieldViolations returns the description of every field of the BadRequest detail of a validation error,
+// none when the request is valid.
+func fieldViolations(t *testing.T, err error) map[string]string {
+	t.Helper()
+
+	violations := make(map[string]string)
+	if err == nil {
+		return violations
+	}
+
+	code, details := connectErrorDetails(t, err)
+	if code != connect.CodeInvalidArgument {
+		t.Fatalf("expected code %s but got %s", connect.CodeInvalidArgument, code)
+	}
+	for _, detail := range details {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				violations[violation.GetField()] = violation.GetDescription()
+			}
+		}
+	}
+	if len(violations) == 0 {
+		t.Fatalf("expected field violations in %v", err)
+	}
+	return violations
+}
+
+func TestValidateSerial(t *testing.T) {
+	tests := []struct {
+		serial    string
+		violation string
+	}{
+		{GenerateSerial(), ""},
+		{strings.ToUpper(GenerateSerial()), ""},
+		{"", "is required"},
+		{"matchmaker", "is not a valid serial"},
+		{GenerateSerial()[1:], "is not a valid serial"},
+	}
+
+	for _, test := range tests {
+		err := ValidateRequest(&donutv1.GetPeopleRequest{MatchmakerSerial: test.serial}, testValidationConfig)
+		if violation := fieldViolations(t, err)["matchmaker_serial"]; violation != test.violation {
+			t.Errorf("%q: expected the violation %q but got %q", test.serial, test.violation, violation)
+		}
+	}
+}
+
+func TestValidateReference(t *testing.T) {
+	const invalid = "must be at most 255 characters without whitespace"
+
+	tests := []struct {
+		reference string
+		violation string
+	}{
+		{"U123ABC", ""},
+		{"<@123456789>", ""},
+		{"ann@example.com", ""},
+		{strings.Repeat("a", 255), ""},
+		{strings.Repeat("é", 255), ""},
+		{"", "is required"},
+		{strings.Repeat("a", 256), invalid},
+		{"ann bob", invalid},
+		{"ann\tbob", invalid},
+		{"ann\n", invalid},
+	}
+
+	for _, test := range tests {
+		err := ValidateRequest(&donutv1.RegisterPeopleRequest{
+			MatchmakerSerial: GenerateSerial(),
+			Reference:        test.reference,
+		}, testValidationConfig)
+		if violation := fieldViolations(t, err)["reference"]; violation != test.violation {
+			t.Errorf("%q: expected the violation %q but got %q", test.reference, test.violation, violation)
+		}
+	}
+}
+
+func TestValidateDurationDays(t *testing.T) {
+	const outOfRange = "must be between 1 and 30 days"
+
+	tests := []struct {
+		days      int32
+		violation string
+	}{
+		{1, ""},
+		{7, ""},
+		{30, ""},
+		{0, outOfRange},
+		{-1, outOfRange},
+		{31, outOfRange},
+	}
+
+	for _, test := range tests {
+		err := ValidateRequest(NewCreateMatchMakerRequest("Coffee", "", nil, test.days), testValidationConfig)
+		if violation := fieldViolations(t, err)["match_maker.duration"]; violation != test.violation {
+			t.Errorf("%d days: expected the violation %q but got %q", test.days, test.violation, violation)
+		}
+	}
+}
+
+func TestValidateStartTime(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		startTime *timestamppb.Timestamp
+		violation string
+	}{
+		{"missing", nil, ""},
+		{"now", timestamppb.New(now), ""},
+		{"tomorrow", timestamppb.New(now.Add(Day)), ""},
+		{"within the tolerance", timestamppb.New(now.Add(-4 * time.Minute)), ""},
+		{"past the tolerance", timestamppb.New(now.Add(-6 * time.Minute)), "must not be in the past"},
+		{"yesterday", timestamppb.New(now.Add(-Day)), "must not be in the past"},
+		{"invalid", &timestamppb.Timestamp{Seconds: now.Unix(), Nanos: -1}, "is not a valid time"},
+	}
+
+	for _, test := range tests {
+		msg := NewCreateMatchMakerRequest("Coffee", "", nil, 7)
+		msg.MatchMaker.StartTime = test.startTime
+
+		err := ValidateRequest(msg, testValidationConfig)
+		if violation := fieldViolations(t, err)["match_maker.start_time"]; violation != test.violation {
+			t.Errorf("%s: expected the violation %q but got %q", test.name, test.violation, violation)
+		}
+	}
+}
+
+// TestValidationErrorDetails checks that every invalid field of a request is reported in a single BadRequest detail
+// after an ErrorInfo, and that ToConnectError keeps them.
+func TestValidationErrorDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		msg        proto.Message
+		violations map[string]string
+	}{
+		{
+			name:       "valid",
+			msg:        NewCreateMatchMakerRequest("Coffee", "Weekly coffee", nil, 7),
+			violations: map[string]string{},
+		},
+		{
+			name:       "missing match maker",
+			msg:        &donutv1.CreateMatchMakerRequest{},
+			violations: map[string]string{"match_maker": "is required"},
+		},
+		{
+			name: "every field of the match maker",
+			msg: func() proto.Message {
+				msg := NewCreateMatchMakerRequest(strings.Repeat("n", maxNameLength+1), strings.Repeat("d", maxDescriptionLength+1), nil, 0)
+				msg.MatchMaker.StartTime = timestamppb.New(time.Now().Add(-Day))
+				return msg
+			}(),
+			violations: map[string]string{
+				"match_maker.name":        "must be at most 255 characters",
+				"match_maker.description": "must be at most 1000 characters",
+				"match_maker.start_time":  "must not be in the past",
+				"match_maker.duration":    "must be between 1 and 30 days",
+			},
+		},
+		{
+			name: "references",
+			msg: &donutv1.CallPeopleRequest{
+				MatchmakerSerial: "matchmaker",
+				References:       []string{"ann", "bob smith", ""},
+			},
+			violations: map[string]string{
+				"matchmaker_serial": "is not a valid serial",
+				"references[1]":     "must be at most 255 characters without whitespace",
+				"references[2]":     "is required",
+			},
+		},
+		{
+			name: "no references",
+			msg: &donutv1.CallPeopleRequest{
+				MatchmakerSerial: GenerateSerial(),
+			},
+			violations: map[string]string{"references": "is required"},
+		},
+	}
+
+	for _, test := range tests {
+		err := ToConnectError(ValidateRequest(test.msg, testValidationConfig))
+
+		violations := fieldViolations(t, err)
+		if len(violations) != len(test.violations) {
+			t.Errorf("%s: expected %d violations but got %v", test.name, len(test.violations), violations)
+		}
+		for field, description := range test.violations {
+			if violations[field] != description {
+				t.Errorf("%s: expected %s %q but got %q", test.name, field, description, violations[field])
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+		_, details := connectErrorDetails(t, err)
+		if info, ok := details[0].(*errdetails.ErrorInfo); !ok || info.GetReason() != errorReasons[ErrValidation] || info.GetDomain() != ErrorDomain {
+			t.Errorf("%s: expected an ErrorInfo of reason %s first but got %v", test.name, errorReasons[ErrValidation], details[0])
+		}
+	}
+}
@@ -0,0 +1,728 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WebhookSignatureHeader = "X-Donut-Signature"
+	WebhookTimestampHeader = "X-Donut-Timestamp"
+	WebhookEventHeader     = "X-Donut-Event"
+	WebhookDeliveryHeader  = "X-Donut-Delivery"
+
+	ActiveColumn             = "active"
+	SubscriptionSerialColumn = "subscription_serial"
+	EventSerialColumn        = "event_serial"
+	NextAttemptAtColumn      = "next_attempt_at"
+)
+
+// maxWebhookBackoff bounds the wait between the attempts of a failed delivery.
+const maxWebhookBackoff = time.Hour
+
+type WebhookConfig struct {
+	Timeout     time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	MaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"5"`
+	Backoff     time.Duration `env:"WEBHOOK_BACKOFF" envDefault:"30s"`
+	// The queued deliveries are sent every interval, Concurrency subscriptions at a time
+	Interval    time.Duration `env:"WEBHOOK_INTERVAL" envDefault:"1s"`
+	BatchSize   int           `env:"WEBHOOK_BATCH_SIZE" envDefault:"100"`
+	Concurrency int           `env:"WEBHOOK_CONCURRENCY" envDefault:"10"`
+	// SecretKey encrypts the secrets of the subscriptions, nobody can subscribe without it
+	SecretKey string `env:"WEBHOOK_SECRET_KEY" secret:"true"`
+	// AllowPrivateNetworks lets the subscriptions call the loopback and the private networks, for local testing
+	AllowPrivateNetworks bool `env:"WEBHOOK_ALLOW_PRIVATE_NETWORKS" envDefault:"false"`
+}
+
+type WebhookSubscriptionEntity struct {
+	Serial string
+	URL    string
+	Secret string
+	Events []EventType
+	Active bool
+}
+
+type WebhookSubscriptionEntityOption func(*WebhookSubscriptionEntity)
+
+func WithWebhookSubscriptionEntityURL(url string) WebhookSubscriptionEntityOption {
+	return func(w *WebhookSubscriptionEntity) {
+		w.URL = url
+	}
+}
+
+func WithWebhookSubscriptionEntitySecret(secret string) WebhookSubscriptionEntityOption {
+	return func(w *WebhookSubscriptionEntity) {
+		w.Secret = secret
+	}
+}
+
+func WithWebhookSubscriptionEntityEvents(events []EventType) WebhookSubscriptionEntityOption {
+	return func(w *WebhookSubscriptionEntity) {
+		w.Events = events
+	}
+}
+
+func (w *WebhookSubscriptionEntity) Build(options ...WebhookSubscriptionEntityOption) *WebhookSubscriptionEntity {
+	w.Serial = GenerateSerial()
+	w.Active = true
+
+	for _, opt := range options {
+		opt(w)
+	}
+
+	return w
+}
+
+func (w *WebhookSubscriptionEntity) Error() error {
+	if w.Serial == "" {
+		return NewValidationError("serial", "is empty")
+	}
+
+	if err := validateOutboundURL(w.URL, true); err != nil {
+		return err
+	}
+
+	if w.Secret == "" {
+		return NewValidationError("secret", "is empty")
+	}
+
+	for _, event := range w.Events {
+		if event != "*" && !event.Known() {
+			return NewValidationError("events", fmt.Sprintf("has %s which is neither an event type nor *", event))
+		}
+	}
+
+	return nil
+}
+
+// Subscribed reports whether the subscription wants the event, no event filter means every event.
+func (w *WebhookSubscriptionEntity) Subscribed(eventType EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, event := range w.Events {
+		if event == eventType || event == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookSubscriptionEntities []*WebhookSubscriptionEntity
+
+type WebhookSubscription struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Serial    string `gorm:"uniqueIndex;size:36"`
+	URL       string
+	Secret    string
+	Events    string
+	Active    bool
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscription"
+}
+
+func (WebhookSubscription) FromEntity(entity *WebhookSubscriptionEntity) *WebhookSubscription {
+	if entity == nil {
+		return nil
+	}
+
+	events := make([]string, 0, len(entity.Events))
+	for _, event := range entity.Events {
+		events = append(events, string(event))
+	}
+
+	return &WebhookSubscription{
+		Serial: entity.Serial,
+		URL:    entity.URL,
+		Secret: entity.Secret,
+		Events: strings.Join(events, ","),
+		Active: entity.Active,
+	}
+}
+
+func (w *WebhookSubscription) ToEntity() *WebhookSubscriptionEntity {
+	if w == nil {
+		return nil
+	}
+
+	var events []EventType
+	for _, event := range strings.Split(w.Events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, EventType(event))
+		}
+	}
+
+	return &WebhookSubscriptionEntity{
+		Serial: w.Serial,
+		URL:    w.URL,
+		Secret: w.Secret,
+		Events: events,
+		Active: w.Active,
+	}
+}
+
+type WebhookSubscriptions []*WebhookSubscription
+
+func (w WebhookSubscriptions) ToEntities() WebhookSubscriptionEntities {
+	var entities WebhookSubscriptionEntities
+	for _, subscription := range w {
+		if subscription == nil {
+			continue
+		}
+		entities = append(entities, subscription.ToEntity())
+	}
+	return entities
+}
+
+// WebhookDeadLetter keeps the deliveries that failed after every attempt, for inspection and manual replay.
+type WebhookDeadLetter struct {
+	ID                 uint64 `gorm:"primaryKey;autoIncrement"`
+	SubscriptionSerial string `gorm:"index;size:36"`
+	EventSerial        string `gorm:"index;size:36"`
+	EventType          EventType
+	Payload            string `gorm:"type:text"`
+	Error              string `gorm:"type:text"`
+	Attempts           int
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
+}
+
+func (WebhookDeadLetter) TableName() string {
+	return "webhook_dead_letter"
+}
+
+// WebhookDelivery queues an event for a subscription. The deliveries are sent by WebhookDispatcher.Run apart
+// from the outbox relay, so a slow or dead subscriber delays nobody else. NextAttemptAt is cleared once the
+// delivery is delivered or dead lettered.
+type WebhookDelivery struct {
+	ID                 uint64 `gorm:"primaryKey;autoIncrement"`
+	SubscriptionSerial string `gorm:"uniqueIndex:idx_webhook_delivery;size:36"`
+	EventSerial        string `gorm:"uniqueIndex:idx_webhook_delivery;size:36"`
+	EventType          EventType
+	Payload            string `gorm:"type:text"`
+	Attempts           int
+	Error              string     `gorm:"type:text"`
+	NextAttemptAt      *time.Time `gorm:"index"`
+	DeliveredAt        *time.Time
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime"`
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_delivery"
+}
+
+type WebhookDeliveries []*WebhookDelivery
+
+// WebhookDeliveryFilter pages the pending deliveries after the delivery AfterID, skipping the subscriptions
+// whose deliveries must wait.
+type WebhookDeliveryFilter struct {
+	AfterID                     uint64
+	ExcludedSubscriptionSerials []string
+	Limit                       int
+}
+
+type webhookRepository struct {
+	db      *gorm.DB
+	secrets *SecretBox
+}
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, subscription *WebhookSubscriptionEntity) error
+	DeleteSubscriptionBySerial(ctx context.Context, serial string) error
+	GetSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error)
+	GetActiveSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error)
+
+	EnqueueDeliveries(ctx context.Context, deliveries WebhookDeliveries) error
+	GetPendingDeliveries(ctx context.Context, filter WebhookDeliveryFilter) (WebhookDeliveries, error)
+	ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error)
+	MarkDeliveryDelivered(ctx context.Context, id uint64) error
+	RescheduleDelivery(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, reason string) error
+	DeadLetterDelivery(ctx context.Context, delivery *WebhookDelivery, attempts int, reason string) error
+}
+
+// NewWebhookRepository stores the secrets of the subscriptions sealed by the box.
+func NewWebhookRepository(db *gorm.DB, secrets *SecretBox) WebhookRepository {
+	return &webhookRepository{
+		db:      db,
+		secrets: secrets,
+	}
+}
+
+func (r *webhookRepository) CreateSubscription(ctx context.Context, subscription *WebhookSubscriptionEntity) error {
+	model := WebhookSubscription{}.FromEntity(subscription)
+
+	secret, err := r.secrets.Seal(model.Secret)
+	if err != nil {
+		return err
+	}
+	model.Secret = secret
+
+	return transactionOrDB(ctx, r.db).Create(model).Error
+}
+
+// DeleteSubscriptionBySerial returns gorm.ErrRecordNotFound when there is no subscription with the serial.
+func (r *webhookRepository) DeleteSubscriptionBySerial(ctx context.Context, serial string) error {
+	q := fmt.Sprintf("%s = ?", SerialColumn)
+	result := transactionOrDB(ctx, r.db).Where(q, serial).Delete(&WebhookSubscription{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *webhookRepository) GetSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error) {
+	var subscriptions WebhookSubscriptions
+	err := transactionOrDB(ctx, r.db).Order("id").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.open(subscriptions)
+}
+
+func (r *webhookRepository) GetActiveSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error) {
+	var subscriptions WebhookSubscriptions
+	q := fmt.Sprintf("%s = ?", ActiveColumn)
+	err := transactionOrDB(ctx, r.db).Where(q, true).Order("id").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.open(subscriptions)
+}
+
+func (r *webhookRepository) open(subscriptions WebhookSubscriptions) (WebhookSubscriptionEntities, error) {
+	entities := subscriptions.ToEntities()
+	for _, entity := range entities {
+		secret, err := r.secrets.Open(entity.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %s: %w", entity.Serial, err)
+		}
+		entity.Secret = secret
+	}
+	return entities, nil
+}
+
+// EnqueueDeliveries queues the deliveries, those of an event already queued for the subscription are skipped
+// so that relaying the event again doesn't deliver it twice.
+func (r *webhookRepository) EnqueueDeliveries(ctx context.Context, deliveries WebhookDeliveries) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return transactionOrDB(ctx, r.db).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: SubscriptionSerialColumn}, {Name: EventSerialColumn}},
+			DoNothing: true,
+		}).
+		Create(deliveries).
+		Error
+}
+
+// GetPendingDeliveries returns the deliveries neither delivered nor dead lettered in the order they were
+// queued, due or not.
+func (r *webhookRepository) GetPendingDeliveries(ctx context.Context, filter WebhookDeliveryFilter) (WebhookDeliveries, error) {
+	q := fmt.Sprintf("%s IS NOT NULL AND %s > ?", NextAttemptAtColumn, IDColumn)
+	query := transactionOrDB(ctx, r.db).Where(q, filter.AfterID)
+
+	if len(filter.ExcludedSubscriptionSerials) > 0 {
+		query = query.Where(fmt.Sprintf("%s NOT IN ?", SubscriptionSerialColumn), filter.ExcludedSubscriptionSerials)
+	}
+
+	var deliveries WebhookDeliveries
+	err := query.Order(IDColumn).Limit(filter.Limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDelivery postpones the next attempt of the delivery until the given time, unless another instance did
+// first, so that only one instance sends it. A delivery whose instance stops in the middle is sent again once
+// the claim runs out.
+func (r *webhookRepository) ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error) {
+	q := fmt.Sprintf("%s = ? AND %s = ?", IDColumn, NextAttemptAtColumn)
+	result := transactionOrDB(ctx, r.db).
+		Model(&WebhookDelivery{}).
+		Where(q, delivery.ID, delivery.NextAttemptAt).
+		Update(NextAttemptAtColumn, until)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *webhookRepository) MarkDeliveryDelivered(ctx context.Context, id uint64) error {
+	q := fmt.Sprintf("%s = ?", IDColumn)
+	return transactionOrDB(ctx, r.db).
+		Model(&WebhookDelivery{}).
+		Where(q, id).
+		Updates(map[string]interface{}{
+			AttemptsColumn:      gorm.Expr(fmt.Sprintf("%s + 1", AttemptsColumn)),
+			ErrorColumn:         "",
+			NextAttemptAtColumn: nil,
+			DeliveredAtColumn:   time.Now(),
+		}).
+		Error
+}
+
+func (r *webhookRepository) RescheduleDelivery(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, reason string) error {
+	q := fmt.Sprintf("%s = ?", IDColumn)
+	return transactionOrDB(ctx, r.db).
+		Model(&WebhookDelivery{}).
+		Where(q, id).
+		Updates(map[string]interface{}{
+			AttemptsColumn:      attempts,
+			ErrorColumn:         reason,
+			NextAttemptAtColumn: nextAttemptAt,
+		}).
+		Error
+}
+
+// DeadLetterDelivery moves the delivery to the dead letters and stops its attempts.
+func (r *webhookRepository) DeadLetterDelivery(ctx context.Context, delivery *WebhookDelivery, attempts int, reason string) error {
+	return transactionOrDB(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&WebhookDeadLetter{
+			SubscriptionSerial: delivery.SubscriptionSerial,
+			EventSerial:        delivery.EventSerial,
+			EventType:          delivery.EventType,
+			Payload:            delivery.Payload,
+			Error:              reason,
+			Attempts:           attempts,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		q := fmt.Sprintf("%s = ?", IDColumn)
+		return tx.Model(&WebhookDelivery{}).
+			Where(q, delivery.ID).
+			Updates(map[string]interface{}{
+				AttemptsColumn:      attempts,
+				ErrorColumn:         reason,
+				NextAttemptAtColumn: nil,
+			}).
+			Error
+	})
+}
+
+type webhookCall struct {
+	repo WebhookRepository
+	cfg  WebhookConfig
+}
+
+type WebhookCall interface {
+	Subscribe(ctx context.Context, subscription *WebhookSubscriptionEntity) (string, error)
+	Unsubscribe(ctx context.Context, serial string) error
+	GetSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error)
+}
+
+func NewWebhookCall(webhookRepository WebhookRepository, cfg WebhookConfig) WebhookCall {
+	return &webhookCall{
+		repo: webhookRepository,
+		cfg:  cfg,
+	}
+}
+
+func (wc *webhookCall) Subscribe(ctx context.Context, subscription *WebhookSubscriptionEntity) (string, error) {
+	if err := subscription.Error(); err != nil {
+		return "", err
+	}
+
+	if err := validateOutboundURL(subscription.URL, wc.cfg.AllowPrivateNetworks); err != nil {
+		return "", err
+	}
+
+	err := wc.repo.CreateSubscription(ctx, subscription)
+	if err != nil {
+		return "", err
+	}
+
+	return subscription.Serial, nil
+}
+
+func (wc *webhookCall) Unsubscribe(ctx context.Context, serial string) error {
+	if serial == "" {
+		return NewValidationError("serial", "is empty")
+	}
+
+	err := wc.repo.DeleteSubscriptionBySerial(ctx, serial)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NewNotFoundError("webhook subscription", serial)
+	}
+	return err
+}
+
+func (wc *webhookCall) GetSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error) {
+	return wc.repo.GetSubscriptions(ctx)
+}
+
+// WebhookDispatcher delivers events to the subscribed webhooks with a signed payload. As a sink of the outbox
+// it only queues a delivery per subscription, Run sends them and retries the failed ones with exponential backoff
+// until they land in the dead letter table once they run out of attempts.
+type WebhookDispatcher struct {
+	repo   WebhookRepository
+	client *http.Client
+	cfg    WebhookConfig
+}
+
+func NewWebhookDispatcher(repo WebhookRepository, cfg WebhookConfig) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repo:   repo,
+		client: newOutboundHTTPClient(cfg.Timeout, cfg.AllowPrivateNetworks),
+		cfg:    cfg,
+	}
+}
+
+func (d *WebhookDispatcher) Name() string {
+	return SinkWebhook
+}
+
+// Publish queues the event for the subscriptions that want it.
+func (d *WebhookDispatcher) Publish(ctx context.Context, event *EventEntity) error {
+	subscriptions, err := d.repo.GetActiveSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := event.Envelope()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var deliveries WebhookDeliveries
+	for _, subscription := range subscriptions {
+		if subscription == nil || !subscription.Subscribed(event.Type) {
+			continue
+		}
+
+		deliveries = append(deliveries, &WebhookDelivery{
+			SubscriptionSerial: subscription.Serial,
+			EventSerial:        event.Serial,
+			EventType:          event.Type,
+			Payload:            string(body),
+			NextAttemptAt:      &now,
+		})
+	}
+
+	return d.repo.EnqueueDeliveries(ctx, deliveries)
+}
+
+// Run sends the due deliveries every interval until the context is done.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.DeliverDue(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to deliver webhooks")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends a batch of due deliveries. The subscriptions are served in parallel and the deliveries of
+// each one in the order they were queued: a subscription whose earliest pending delivery is not due yet, because
+// it waits for its backoff or another instance claimed it, is left out of the run so that its later deliveries
+// don't overtake it. A subscription stops at its first failure so that a dead one takes a single attempt per run.
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) error {
+	var order []string
+	queues := make(map[string]WebhookDeliveries)
+	queued := 0
+
+	filter := WebhookDeliveryFilter{Limit: d.cfg.BatchSize}
+	blocked := make(map[string]struct{})
+	now := time.Now()
+
+	for queued < d.cfg.BatchSize {
+		deliveries, err := d.repo.GetPendingDeliveries(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, delivery := range deliveries {
+			filter.AfterID = delivery.ID
+
+			if _, ok := blocked[delivery.SubscriptionSerial]; ok {
+				continue
+			}
+
+			if delivery.NextAttemptAt != nil && now.Before(*delivery.NextAttemptAt) {
+				blocked[delivery.SubscriptionSerial] = struct{}{}
+				filter.ExcludedSubscriptionSerials = append(filter.ExcludedSubscriptionSerials, delivery.SubscriptionSerial)
+				continue
+			}
+
+			if _, ok := queues[delivery.SubscriptionSerial]; !ok {
+				order = append(order, delivery.SubscriptionSerial)
+			}
+			queues[delivery.SubscriptionSerial] = append(queues[delivery.SubscriptionSerial], delivery)
+			queued++
+		}
+
+		if len(deliveries) < filter.Limit {
+			break
+		}
+	}
+
+	if queued == 0 {
+		return nil
+	}
+
+	subscriptions, err := d.repo.GetSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	bySerial := make(map[string]*WebhookSubscriptionEntity, len(subscriptions))
+	for _, subscription := range subscriptions {
+		bySerial[subscription.Serial] = subscription
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.cfg.Concurrency)
+	for _, serial := range order {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(subscription *WebhookSubscriptionEntity, queue WebhookDeliveries) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			for _, delivery := range queue {
+				if err := d.attempt(ctx, subscription, delivery); err != nil {
+					return
+				}
+			}
+		}(bySerial[serial], queues[serial])
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// attempt sends the delivery once and records the outcome, it returns the error of a failed attempt.
+// The deliveries of a deleted or inactive subscription are dead lettered without any.
+func (d *WebhookDispatcher) attempt(ctx context.Context, subscription *WebhookSubscriptionEntity, delivery *WebhookDelivery) error {
+	claimed, err := d.repo.ClaimDelivery(ctx, delivery, time.Now().Add(2*d.cfg.Timeout))
+	if err != nil || !claimed {
+		return err
+	}
+
+	if subscription == nil || !subscription.Active {
+		d.deadLetter(ctx, delivery, delivery.Attempts, fmt.Errorf("subscription is deleted or inactive"))
+		return nil
+	}
+
+	err = d.deliver(ctx, subscription, delivery)
+	if err == nil {
+		if err := d.repo.MarkDeliveryDelivered(ctx, delivery.ID); err != nil {
+			log.Error().Err(err).Str("event", delivery.EventSerial).Msg("failed to mark webhook delivered")
+		}
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.cfg.MaxAttempts {
+		d.deadLetter(ctx, delivery, attempts, err)
+		return err
+	}
+
+	backoff := maxWebhookBackoff
+	if shift := attempts - 1; shift < 32 && d.cfg.Backoff<<shift < maxWebhookBackoff {
+		backoff = d.cfg.Backoff << shift
+	}
+	log.Warn().Err(err).
+		Str("subscription", delivery.SubscriptionSerial).
+		Str("event", delivery.EventSerial).
+		Int("attempts", attempts).
+		Dur("backoff", backoff).
+		Msg("webhook delivery failed, retrying later")
+
+	if err := d.repo.RescheduleDelivery(ctx, delivery.ID, attempts, time.Now().Add(backoff), err.Error()); err != nil {
+		log.Error().Err(err).Str("event", delivery.EventSerial).Msg("failed to reschedule webhook delivery")
+	}
+	return err
+}
+
+func (d *WebhookDispatcher) deadLetter(ctx context.Context, delivery *WebhookDelivery, attempts int, reason error) {
+	log.Warn().Err(reason).
+		Str("subscription", delivery.SubscriptionSerial).
+		Str("event", delivery.EventSerial).
+		Msg("webhook delivery failed, moving to dead letter")
+
+	if err := d.repo.DeadLetterDelivery(ctx, delivery, attempts, reason.Error()); err != nil {
+		log.Error().Err(err).Str("event", delivery.EventSerial).Msg("failed to dead letter webhook delivery")
+	}
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, subscription *WebhookSubscriptionEntity, delivery *WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.EventType))
+	req.Header.Set(WebhookDeliveryHeader, delivery.EventSerial)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(subscription.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SignWebhookPayload signs "<timestamp>.<body>" with HMAC-SHA256, receivers recompute it with the
+// shared secret and reject stale timestamps to prevent replays.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
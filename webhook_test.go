@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testWebhookRepository keeps the deliveries in memory, the other methods are not used by the dispatcher.
+type testWebhookRepository struct {
+	WebhookRepository
+
+	subscriptions WebhookSubscriptionEntities
+	deliveries    WebhookDeliveries
+}
+
+func (r *testWebhookRepository) GetSubscriptions(ctx context.Context) (WebhookSubscriptionEntities, error) {
+	return r.subscriptions, nil
+}
+
+func (r *testWebhookRepository) GetPendingDeliveries(ctx context.Context, filter WebhookDeliveryFilter) (WebhookDeliveries, error) {
+	excluded := make(map[string]bool)
+	for _, serial := range filter.ExcludedSubscriptionSerials {
+		excluded[serial] = true
+	}
+
+	var deliveries WebhookDeliveries
+	for _, delivery := range r.deliveries {
+		if delivery.NextAttemptAt == nil || delivery.ID <= filter.AfterID || excluded[delivery.SubscriptionSerial] {
+			continue
+		}
+		if len(deliveries) == filter.Limit {
+			break
+		}
+		copied := *delivery
+		deliveries = append(deliveries, &copied)
+	}
+	return deliveries, nil
+}
+
+func (r *testWebhookRepository) get(id uint64) *WebhookDelivery {
+	for _, delivery := range r.deliveries {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+	return nil
+}
+
+func (r *testWebhookRepository) ClaimDelivery(ctx context.Context, delivery *WebhookDelivery, until time.Time) (bool, error) {
+	stored := r.get(delivery.ID)
+	if stored.NextAttemptAt == nil || !stored.NextAttemptAt.Equal(*delivery.NextAttemptAt) {
+		return false, nil
+	}
+	stored.NextAttemptAt = &until
+	return true, nil
+}
+
+func (r *testWebhookRepository) MarkDeliveryDelivered(ctx context.Context, id uint64) error {
+	now := time.Now()
+	stored := r.get(id)
+	stored.Attempts++
+	stored.NextAttemptAt = nil
+	stored.DeliveredAt = &now
+	return nil
+}
+
+func (r *testWebhookRepository) RescheduleDelivery(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, reason string) error {
+	stored := r.get(id)
+	stored.Attempts = attempts
+	stored.Error = reason
+	stored.NextAttemptAt = &nextAttemptAt
+	return nil
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+		expected  string
+	}{
+		{
+			secret:    "whsec_test",
+			timestamp: "1700000000",
+			body:      `{"type":"matchmaker.created"}`,
+			expected:  "sha256=0bc3b9c7e16090c09c52342b7cd4ad371256599955f93e43bea217269057d8e7",
+		},
+		{
+			secret:    "whsec_test",
+			timestamp: "1700000000",
+			body:      "",
+			expected:  "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc",
+		},
+	}
+
+	for _, test := range tests {
+		if signature := SignWebhookPayload(test.secret, test.timestamp, []byte(test.body)); signature != test.expected {
+			t.Errorf("%s.%s: expected %s but got %s", test.timestamp, test.body, test.expected, signature)
+		}
+	}
+}
+
+func TestWebhookSubscriptionEvents(t *testing.T) {
+	tests := []struct {
+		events []EventType
+		valid  bool
+	}{
+		{nil, true},
+		{[]EventType{"*"}, true},
+		{[]EventType{EventTypeMatchMakerCreated, EventTypePeopleRestored}, true},
+		{[]EventType{EventTypeMatchMakerCreated, "matchmaker.deleted"}, false},
+		{[]EventType{""}, false},
+	}
+
+	for _, test := range tests {
+		subscription := (&WebhookSubscriptionEntity{}).Build(
+			WithWebhookSubscriptionEntityURL("https://example.com/hook"),
+			WithWebhookSubscriptionEntitySecret("secret"),
+			WithWebhookSubscriptionEntityEvents(test.events),
+		)
+
+		err := subscription.Error()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got %v", test.events, test.valid, err)
+		}
+		if err != nil && HTTPStatus(err) != http.StatusBadRequest {
+			t.Errorf("%v: expected status %d but got %d", test.events, http.StatusBadRequest, HTTPStatus(err))
+		}
+	}
+}
+
+// TestWebhookDeliverDueOrder queues a delivery waiting for its backoff before a due one of the same subscription:
+// the due one must not overtake it, while the deliveries of another subscription are sent.
+func TestWebhookDeliverDueOrder(t *testing.T) {
+	server := newTestWebhookServer(t)
+
+	waiting := (&WebhookSubscriptionEntity{}).Build(WithWebhookSubscriptionEntityURL(server.URL), WithWebhookSubscriptionEntitySecret("secret"))
+	other := (&WebhookSubscriptionEntity{}).Build(WithWebhookSubscriptionEntityURL(server.URL), WithWebhookSubscriptionEntitySecret("secret"))
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+	delivery := func(id uint64, subscription *WebhookSubscriptionEntity, nextAttemptAt time.Time) *WebhookDelivery {
+		return &WebhookDelivery{
+			ID:                 id,
+			SubscriptionSerial: subscription.Serial,
+			EventSerial:        fmt.Sprintf("event-%d", id),
+			Payload:            fmt.Sprintf(`{"id":%d}`, id),
+			NextAttemptAt:      &nextAttemptAt,
+		}
+	}
+
+	repo := &testWebhookRepository{
+		subscriptions: WebhookSubscriptionEntities{waiting, other},
+		deliveries: WebhookDeliveries{
+			delivery(1, waiting, future),
+			delivery(2, other, past),
+			delivery(3, waiting, past),
+			delivery(4, other, past),
+		},
+	}
+	d := NewWebhookDispatcher(repo, WebhookConfig{
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		BatchSize:   2,
+		Concurrency: 2,
+	})
+	// The test server is on the loopback, which the outbound client refuses
+	d.client = server.Client()
+
+	if err := d.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []float64
+	for _, body := range server.received() {
+		id, _ := body["id"].(float64)
+		sent = append(sent, id)
+	}
+	if len(sent) != 2 || sent[0] != 2 || sent[1] != 4 {
+		t.Errorf("expected the deliveries 2 and 4 to be sent but got %v", sent)
+	}
+	if repo.get(3).DeliveredAt != nil {
+		t.Error("expected the delivery 3 to wait for the delivery 1")
+	}
+}
+
+func TestWebhookBackoffIsCapped(t *testing.T) {
+	server := newTestWebhookServer(t)
+	server.respond(http.StatusInternalServerError)
+
+	subscription := (&WebhookSubscriptionEntity{}).Build(WithWebhookSubscriptionEntityURL(server.URL), WithWebhookSubscriptionEntitySecret("secret"))
+	nextAttemptAt := time.Now().Add(-time.Minute)
+	repo := &testWebhookRepository{
+		deliveries: WebhookDeliveries{{
+			ID:                 1,
+			SubscriptionSerial: subscription.Serial,
+			EventSerial:        "event",
+			Payload:            "{}",
+			Attempts:           60,
+			NextAttemptAt:      &nextAttemptAt,
+		}},
+	}
+	d := NewWebhookDispatcher(repo, WebhookConfig{
+		Timeout:     time.Second,
+		MaxAttempts: 100,
+		Backoff:     30 * time.Second,
+	})
+	d.client = server.Client()
+
+	delivery := *repo.deliveries[0]
+	if err := d.attempt(context.Background(), subscription, &delivery); err == nil {
+		t.Fatal("expected the failed attempt to return an error")
+	}
+
+	wait := time.Until(*repo.get(1).NextAttemptAt)
+	if wait <= 0 || wait > maxWebhookBackoff {
+		t.Errorf("expected the next attempt within %s but got %s", maxWebhookBackoff, wait)
+	}
+}